@@ -0,0 +1,33 @@
+#!/bin/bash
+
+# Generates a throwaway CA plus broker and client certificates in ./certs for
+# running RabbitMQ over amqps locally. The client certificate's common name is
+# the user the broker authenticates with EXTERNAL auth.
+
+set -e
+
+dir=${CERTS_DIR:-certs}
+client=${1:-peril}
+
+mkdir -p "$dir"
+cd "$dir"
+
+openssl req -x509 -newkey rsa:2048 -nodes -days 365 \
+  -subj "/CN=Peril Local CA" -keyout ca.key -out ca.pem
+
+openssl req -newkey rsa:2048 -nodes -subj "/CN=localhost" \
+  -keyout server.key -out server.csr
+openssl x509 -req -in server.csr -CA ca.pem -CAkey ca.key -CAcreateserial -days 365 \
+  -extfile <(printf "subjectAltName=DNS:localhost,IP:127.0.0.1\nextendedKeyUsage=serverAuth") \
+  -out server.pem
+
+openssl req -newkey rsa:2048 -nodes -subj "/CN=$client" \
+  -keyout client.key -out client.csr
+openssl x509 -req -in client.csr -CA ca.pem -CAkey ca.key -CAcreateserial -days 365 \
+  -extfile <(printf "extendedKeyUsage=clientAuth") \
+  -out client.pem
+
+rm -f server.csr client.csr ca.srl
+chmod 600 ca.key server.key client.key
+
+echo "Certificates written to $dir (client user: $client)"
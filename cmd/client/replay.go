@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// replayIdle is how long a replay waits for more history before it assumes
+// it has caught up.
+const replayIdle = 2 * time.Second
+
+type replayEntry struct {
+	at   time.Time
+	line string
+}
+
+// replayMatch prints every army move and game log kept in the history
+// streams, oldest first. With a duration it only replays that much of the
+// match.
+func replayMatch(ctx context.Context, conn pubsub.Broker, input []string) {
+	offset := pubsub.OffsetFirst
+	if len(input) > 1 {
+		d, err := time.ParseDuration(input[1])
+		if err != nil {
+			fmt.Println("usage: replay [duration]")
+			return
+		}
+		offset = pubsub.OffsetTimestamp(time.Now().Add(-d))
+	}
+
+	var entries []replayEntry
+	_, err := pubsub.ReplayStream(ctx, conn, routing.ArmyMovesStream, offset, replayIdle, func(move gamelogic.ArmyMove, env pubsub.Envelope) {
+		entries = append(entries, replayEntry{env.SentAt, fmt.Sprintf("%s moved %d unit(s) to %s", move.Player.Username, len(move.Units), move.ToLocation)})
+	}, pubsub.WithDefaultContentType(pubsub.ContentTypeJSON))
+	if err != nil {
+		fmt.Println("Unable to replay army moves:", err)
+		return
+	}
+	_, err = pubsub.ReplayStream(ctx, conn, routing.GameLogStream, offset, replayIdle, func(gl routing.GameLog, env pubsub.Envelope) {
+		entries = append(entries, replayEntry{gl.CurrentTime, fmt.Sprintf("%s: %s", gl.Username, gl.Message)})
+	}, pubsub.WithDefaultContentType(pubsub.ContentTypeGob))
+	if err != nil {
+		fmt.Println("Unable to replay game logs:", err)
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("Nothing to replay.")
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+	for _, e := range entries {
+		fmt.Printf("%s %s\n", e.at.Format("15:04:05"), e.line)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func printDeadLetters(deadLetters []pubsub.DeadLetter) {
+	if len(deadLetters) == 0 {
+		fmt.Println("There are no dead-lettered messages.")
+		return
+	}
+	fmt.Printf("%d dead-lettered message(s):\n", len(deadLetters))
+	for i, dl := range deadLetters {
+		fmt.Printf("%d. %s from queue %s (%s -> %s), %d time(s) at %s\n", i+1, dl.Reason, dl.Queue, dl.Exchange, dl.RoutingKey, dl.Count, dl.Time.Format("15:04:05"))
+		if dl.Error != "" {
+			fmt.Printf("   error: %s\n", dl.Error)
+		}
+		fmt.Printf("   %s\n", decodeDeadLetter(dl))
+	}
+}
+
+func decodeDeadLetter(dl pubsub.DeadLetter) string {
+	d := dl.Delivery
+	codec, err := pubsub.CodecFor(d.ContentType)
+	if err != nil {
+		return fmt.Sprintf("<%d bytes of %s>", len(d.Body), d.ContentType)
+	}
+
+	var val any
+	prefix, _, _ := strings.Cut(dl.RoutingKey, ".")
+	switch prefix {
+	case routing.GameLogSlug:
+		val = &routing.GameLog{}
+	case routing.ArmyMovesPrefix:
+		val = &gamelogic.ArmyMove{}
+	case routing.WarRecognitionsPrefix:
+		val = &gamelogic.RecognitionOfWar{}
+	case routing.PauseKey:
+		val = &routing.PlayingState{}
+	default:
+		return fmt.Sprintf("<%d bytes of %s>", len(d.Body), d.ContentType)
+	}
+	err = codec.Unmarshal(d.Body, val)
+	if err != nil {
+		return fmt.Sprintf("<undecodable %s: %v>", d.ContentType, err)
+	}
+	return fmt.Sprintf("%+v", val)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// rebuildIdle is how long a rebuild waits for more game logs before it
+// assumes it has read the whole stream.
+const rebuildIdle = 2 * time.Second
+
+// gameLogFile stops a rebuild from replacing the game log file while game
+// logs are being written to it.
+type gameLogFile struct {
+	path string
+	mu   sync.RWMutex
+
+	writtenMu sync.Mutex
+	// written holds the game logs written while a rebuild runs, by message
+	// ID, so those the replay did not reach still end up in the new file.
+	written map[string]routing.GameLog
+}
+
+func (f *gameLogFile) write(id string, gl routing.GameLog) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	err := gamelogic.WriteLog(gl)
+	if err != nil {
+		return err
+	}
+	f.writtenMu.Lock()
+	defer f.writtenMu.Unlock()
+	if f.written != nil {
+		f.written[id] = gl
+	}
+	return nil
+}
+
+// rebuild rewrites the file from every game log still in the game log
+// stream. The new file replaces the old one only once the stream has been
+// read, together with any game log written in the meantime.
+func (f *gameLogFile) rebuild(ctx context.Context, conn pubsub.Broker) {
+	tmp := f.path + ".rebuild"
+	err := os.Remove(tmp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Println("Unable to rebuild game logs:", err)
+		return
+	}
+
+	f.writtenMu.Lock()
+	f.written = map[string]routing.GameLog{}
+	f.writtenMu.Unlock()
+	replayed := map[string]bool{}
+	var writeErr error
+	n, err := pubsub.ReplayStream(ctx, conn, routing.GameLogStream, pubsub.OffsetFirst, rebuildIdle, func(gl routing.GameLog, env pubsub.Envelope) {
+		replayed[env.MessageID] = true
+		if writeErr == nil {
+			writeErr = gamelogic.AppendLog(tmp, gl)
+		}
+	}, pubsub.WithDefaultContentType(pubsub.ContentTypeGob))
+	if err == nil {
+		err = writeErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writtenMu.Lock()
+	written := f.written
+	f.written = nil
+	f.writtenMu.Unlock()
+	missing := 0
+	if err == nil && n > 0 {
+		missing, err = appendMissing(tmp, written, replayed)
+	}
+	if err == nil && n > 0 {
+		err = os.Rename(tmp, f.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Println("Unable to rebuild game logs:", err)
+		return
+	}
+	if n == 0 {
+		fmt.Printf("The game log stream is empty, so %s was left as it is\n", f.path)
+		return
+	}
+	fmt.Printf("Rebuilt %s from %d game log(s)\n", f.path, n+missing)
+}
+
+// appendMissing appends the game logs in written that were not replayed to
+// path, oldest first, and reports how many there were.
+func appendMissing(path string, written map[string]routing.GameLog, replayed map[string]bool) (int, error) {
+	var missing []routing.GameLog
+	for id, gl := range written {
+		if !replayed[id] {
+			missing = append(missing, gl)
+		}
+	}
+	slices.SortFunc(missing, func(a, b routing.GameLog) int {
+		return a.CurrentTime.Compare(b.CurrentTime)
+	})
+	for _, gl := range missing {
+		err := gamelogic.AppendLog(path, gl)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(missing), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type scheduledJob struct {
+	id     string
+	at     time.Time
+	action string
+	timer  *time.Timer
+}
+
+// scheduledJobs remembers what this server has scheduled, so they can be
+// listed.
+type scheduledJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+}
+
+func newScheduledJobs() *scheduledJobs {
+	return &scheduledJobs{jobs: map[string]*scheduledJob{}}
+}
+
+func handleSchedule(ctx context.Context, conn pubsub.Broker, pub pubsub.Publisher, ex routing.Exchanges, jobs *scheduledJobs, input []string) {
+	switch {
+	case len(input) == 1:
+		jobs.print()
+	case len(input) == 3 && input[1] == "cancel":
+		cancelled, err := pubsub.CancelScheduled(conn, input[2])
+		if err != nil {
+			fmt.Println("Unable to cancel scheduled message:", err)
+			return
+		}
+		jobs.remove(input[2])
+		if !cancelled {
+			fmt.Printf("No pending scheduled message %s\n", input[2])
+			return
+		}
+		fmt.Printf("Cancelled %s\n", input[2])
+	case len(input) == 3 && (input[2] == "pause" || input[2] == "resume"):
+		delay, err := time.ParseDuration(input[1])
+		if err != nil || delay < 0 {
+			fmt.Println("usage: schedule <duration> pause|resume")
+			return
+		}
+		state := routing.PlayingState{IsPaused: input[2] == "pause"}
+		id, err := pubsub.PublishAfter(ctx, conn, pub, delay, ex.Direct, routing.PauseKey, state, pubsub.WithRetain(), pubsub.WithPriority(routing.PriorityControl))
+		if err != nil {
+			fmt.Println("Unable to schedule message:", err)
+			return
+		}
+		jobs.add(&scheduledJob{id: id, at: time.Now().Add(delay), action: input[2]})
+		fmt.Printf("Scheduled %s in %s, id %s\n", input[2], delay, id)
+	default:
+		fmt.Println("usage: schedule [<duration> pause|resume | cancel <id>]")
+	}
+}
+
+func (s *scheduledJobs) add(job *scheduledJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.id] = job
+	job.timer = time.AfterFunc(time.Until(job.at), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.jobs, job.id)
+	})
+}
+
+func (s *scheduledJobs) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		job.timer.Stop()
+		delete(s.jobs, id)
+	}
+}
+
+func (s *scheduledJobs) print() {
+	s.mu.Lock()
+	jobs := make([]*scheduledJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	s.mu.Unlock()
+
+	if len(jobs) == 0 {
+		fmt.Println("Nothing is scheduled.")
+		return
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].at.Before(jobs[j].at) })
+	for _, job := range jobs {
+		fmt.Printf("%s %s at %s\n", job.id, job.action, job.at.Format("15:04:05"))
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting is one value that can be set by both a flag and an environment
+// variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func str(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func duration(field func(c *Config) *time.Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolean(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"broker-url", "PERIL_BROKER_URL", "broker URL: amqp://, amqps://, stomp://, stomps://, mqtt:// or mqtts:// (add ?version=5 for MQTT 5)", str(func(c *Config) *string { return &c.Broker.URL })},
+	{"broker-vhost", "PERIL_BROKER_VHOST", "virtual host, overriding the one in the URL", str(func(c *Config) *string { return &c.Broker.VHost })},
+	{"broker-username", "PERIL_BROKER_USERNAME", "broker username, overriding the one in the URL", str(func(c *Config) *string { return &c.Broker.Username })},
+	{"broker-password", "PERIL_BROKER_PASSWORD", "broker password, overriding the one in the URL", str(func(c *Config) *string { return &c.Broker.Password })},
+	{"broker-password-file", "PERIL_BROKER_PASSWORD_FILE", "read the broker password from this file", str(func(c *Config) *string { return &c.Broker.PasswordFile })},
+	{"broker-auth", "PERIL_BROKER_AUTH", "broker authentication: plain or external (TLS client certificate)", str(func(c *Config) *string { return &c.Broker.Auth })},
+	{"broker-connection-name", "PERIL_BROKER_CONNECTION_NAME", "name shown for this connection in the broker", str(func(c *Config) *string { return &c.Broker.ConnectionName })},
+	{"tls-ca-file", "PERIL_TLS_CA_FILE", "CA bundle used to verify the broker", str(func(c *Config) *string { return &c.Broker.TLS.CAFile })},
+	{"tls-cert-file", "PERIL_TLS_CERT_FILE", "TLS client certificate", str(func(c *Config) *string { return &c.Broker.TLS.CertFile })},
+	{"tls-key-file", "PERIL_TLS_KEY_FILE", "TLS client key", str(func(c *Config) *string { return &c.Broker.TLS.KeyFile })},
+	{"tls-server-name", "PERIL_TLS_SERVER_NAME", "expected broker certificate name", str(func(c *Config) *string { return &c.Broker.TLS.ServerName })},
+	{"tls-insecure-skip-verify", "PERIL_TLS_INSECURE_SKIP_VERIFY", "do not verify the broker certificate", boolean(func(c *Config) *bool { return &c.Broker.TLS.InsecureSkipVerify })},
+	{"exchange-direct", "PERIL_EXCHANGE_DIRECT", "name of the direct exchange", str(func(c *Config) *string { return &c.Exchanges.Direct })},
+	{"exchange-topic", "PERIL_EXCHANGE_TOPIC", "name of the topic exchange", str(func(c *Config) *string { return &c.Exchanges.Topic })},
+	{"exchange-dlx", "PERIL_EXCHANGE_DLX", "name of the dead-letter exchange", str(func(c *Config) *string { return &c.Exchanges.DLX })},
+	{"game-log-file", "PERIL_GAME_LOG_FILE", "file the server writes game logs to", str(func(c *Config) *string { return &c.Game.LogFile })},
+	{"game-write-delay", "PERIL_GAME_WRITE_DELAY", "simulated disk delay per game log", duration(func(c *Config) *time.Duration { return &c.Game.WriteDelay })},
+	{"log-level", "PERIL_LOG_LEVEL", "diagnostics log level: debug, info, warn or error", str(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "PERIL_LOG_FORMAT", "diagnostics log format: text or json", str(func(c *Config) *string { return &c.Log.Format })},
+	{"log-file", "PERIL_LOG_FILE", "write diagnostics to this file instead of stderr", str(func(c *Config) *string { return &c.Log.File })},
+	{"metrics-addr", "PERIL_METRICS_ADDR", "serve Prometheus metrics at /metrics on this address, e.g. :2112", str(func(c *Config) *string { return &c.Telemetry.MetricsAddr })},
+	{"trace-exporter", "PERIL_TRACE_EXPORTER", "export traces to \"stdout\" or \"otlp\"", str(func(c *Config) *string { return &c.Telemetry.TraceExporter })},
+}
+
+// Load builds the configuration from defaults, the file named by -config or
+// PERIL_CONFIG, the environment and args, in increasing precedence.
+func Load(name string, args []string) (Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("PERIL_CONFIG"), "YAML or TOML config file (env PERIL_CONFIG)")
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	if *configFile != "" {
+		err = loadFile(*configFile, &cfg)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		v, ok := os.LookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		err = s.set(&cfg, v)
+		if err != nil {
+			return Config{}, fmt.Errorf("config: %s: %w", s.env, err)
+		}
+	}
+
+	byFlag := map[string]setting{}
+	for _, s := range settings {
+		byFlag[s.flag] = s
+	}
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := byFlag[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := s.set(&cfg, f.Value.String()); setErr != nil {
+			err = fmt.Errorf("config: -%s: %w", f.Name, setErr)
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		err = cfg.resolveSecrets()
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("config: %w", err)
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		_, err = toml.Decode(string(data), cfg)
+	default:
+		return fmt.Errorf("config: %s: unknown file type, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "peril.yaml", "broker:\n  vhost: file\n")
+	tests := []struct {
+		name string
+		file bool
+		env  string
+		flag string
+		want string
+	}{
+		{"default", false, "", "", ""},
+		{"file over default", true, "", "", "file"},
+		{"env over file", true, "env", "", "env"},
+		{"env over default", false, "env", "", "env"},
+		{"flag over env", true, "env", "flag", "flag"},
+		{"flag over file", true, "", "flag", "flag"},
+		{"flag over default", false, "", "flag", "flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.file {
+				args = append(args, "-config", file)
+			}
+			if tt.env != "" {
+				t.Setenv("PERIL_BROKER_VHOST", tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "-broker-vhost", tt.flag)
+			}
+			cfg, err := Load("peril", args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Broker.VHost != tt.want {
+				t.Errorf("vhost = %q, want %q", cfg.Broker.VHost, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("PERIL_CONFIG", writeConfigFile(t, "peril.yaml", "log:\n  level: debug\n"))
+	cfg, err := Load("peril", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("log level = %q, want the file's debug", cfg.Log.Level)
+	}
+
+	// -config takes precedence over PERIL_CONFIG.
+	cfg, err = Load("peril", []string{"-config", writeConfigFile(t, "other.yaml", "log:\n  level: error\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Log.Level != "error" {
+		t.Errorf("log level = %q, want the -config file's error", cfg.Log.Level)
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	yamlFile := writeConfigFile(t, "peril.yaml", `
+broker:
+  url: amqps://localhost:5671/
+  tls:
+    server_name: rabbit
+exchanges:
+  dlx: game_dlx
+queues:
+  war:
+    prefetch: 5
+    decode_failure: quarantine
+    retry:
+      base_delay: 100ms
+      max_attempts: 3
+  player:
+    type: classic
+    max_priority: 10
+game:
+  write_delay: 250ms
+`)
+	tomlFile := writeConfigFile(t, "peril.toml", `
+[broker]
+url = "amqps://localhost:5671/"
+
+[broker.tls]
+server_name = "rabbit"
+
+[exchanges]
+dlx = "game_dlx"
+
+[queues.war]
+prefetch = 5
+decode_failure = "quarantine"
+
+[queues.war.retry]
+base_delay = "100ms"
+max_attempts = 3
+
+[queues.player]
+type = "classic"
+max_priority = 10
+
+[game]
+write_delay = "250ms"
+`)
+
+	want := Default()
+	want.Broker.URL = "amqps://localhost:5671/"
+	want.Broker.TLS.ServerName = "rabbit"
+	want.Exchanges.DLX = "game_dlx"
+	war := QueueConfig{Prefetch: 5, DecodeFailure: "quarantine"}
+	war.Retry.BaseDelay = 100 * time.Millisecond
+	war.Retry.MaxAttempts = 3
+	want.Queues = map[string]QueueConfig{
+		"war":    war,
+		"player": {Type: "classic", MaxPriority: 10},
+	}
+	want.Game.WriteDelay = 250 * time.Millisecond
+
+	for _, file := range []string{yamlFile, tomlFile} {
+		cfg, err := Load("peril", []string{"-config", file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", filepath.Base(file), cfg, want)
+		}
+	}
+
+	if _, err := Load("peril", []string{"-config", writeConfigFile(t, "peril.json", "{}")}); err == nil {
+		t.Error("loaded a .json config file")
+	}
+	if _, err := Load("peril", []string{"-config", writeConfigFile(t, "peril.yaml", "queues:\n  war:\n    prefetch: -1\n")}); err == nil {
+		t.Error("loaded a negative prefetch")
+	}
+}
+
+func TestSubscribeOptions(t *testing.T) {
+	c := Default()
+	c.Exchanges.DLX = "game_dlx"
+	c.Queues = map[string]QueueConfig{
+		"war":    {Prefetch: 5, Concurrency: 2},
+		"player": {MaxPriority: 10},
+	}
+
+	if spec := pubsub.NewQueueSpec(c.Queues["player"].queueOptions()...); spec.MaxPriority != 10 {
+		t.Errorf("player queue spec %+v, want a max priority of 10", spec)
+	}
+	tests := []struct {
+		queue string
+		want  int
+	}{
+		// Every queue gets the dead-letter exchange.
+		{"game_logs", 1},
+		{"war", 3},
+		// Per-player queues fall back to their prefix.
+		{"player.alice", 2},
+	}
+	for _, tt := range tests {
+		if got := len(c.SubscribeOptions(tt.queue)); got != tt.want {
+			t.Errorf("SubscribeOptions(%q) has %d options, want %d", tt.queue, got, tt.want)
+		}
+	}
+
+	// A rejected message goes to the configured dead-letter exchange.
+	b := pubsub.NewMemoryBroker().Connect()
+	ch, err := b.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	if err := pubsub.DeclareTopology(b, pubsub.PerilTopology(c.Exchanges.Names())); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := pubsub.Subscribe(context.Background(), b, c.Exchanges.Topic, "war", "war.*", pubsub.Durable, func(int) pubsub.AckType {
+		return pubsub.NackDiscard
+	}, c.SubscribeOptions("war")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := pubsub.PublishJSON(ch, c.Exchanges.Topic, "war.alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, ok, err := ch.Get(routing.DeadLetterQueue, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the rejected message was not dead-lettered to game_dlx")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -18,6 +18,9 @@ func PrintClientHelp() {
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
 	fmt.Println("* status")
+	fmt.Println("* replay [duration]")
+	fmt.Println("    example:")
+	fmt.Println("    replay 10m")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -42,6 +45,11 @@ func PrintServerHelp() {
 	fmt.Println("Possible commands:")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
+	fmt.Println("* schedule [<duration> pause|resume | cancel <id>]")
+	fmt.Println("    example:")
+	fmt.Println("    schedule 5m resume")
+	fmt.Println("* deadletters [list|republish|purge]")
+	fmt.Println("* rebuild")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -48,11 +48,17 @@ func (gs *GameState) addUnit(u Unit) {
 func (gs *GameState) removeUnitsInLocation(loc Location) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
+	var killed []int
 	for k, v := range gs.Player.Units {
 		if v.Location == loc {
 			delete(gs.Player.Units, k)
+			unitsKilledTotal.WithLabelValues(string(v.Rank)).Inc()
+			killed = append(killed, v.ID)
 		}
 	}
+	if len(killed) > 0 {
+		logger().Info("units killed", "username", gs.Player.Username, "location", loc, "unit_ids", killed)
+	}
 }
 
 func (gs *GameState) UpdateUnit(u Unit) {
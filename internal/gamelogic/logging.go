@@ -0,0 +1,29 @@
+package gamelogic
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+var pkgLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger for game diagnostics. Narration meant for the
+// player is still printed to stdout.
+func SetLogger(l *slog.Logger) {
+	pkgLogger.Store(l.With("component", "gamelogic"))
+}
+
+func logger() *slog.Logger {
+	if l := pkgLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default().With("component", "gamelogic")
+}
+
+func unitIDs(units []Unit) []int {
+	ids := make([]int, 0, len(units))
+	for _, u := range units {
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
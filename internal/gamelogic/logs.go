@@ -2,22 +2,36 @@ package gamelogic
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-const logsFile = "game.log"
+var (
+	logsFile         = "game.log"
+	writeToDiskSleep = 1 * time.Second
+)
 
-const writeToDiskSleep = 1 * time.Second
+// ConfigureLogs sets the file WriteLog appends to and the simulated disk
+// delay before each write.
+func ConfigureLogs(path string, delay time.Duration) {
+	logsFile = path
+	writeToDiskSleep = delay
+}
 
 func WriteLog(gamelog routing.GameLog) error {
-	log.Printf("received game log...")
+	defer prometheus.NewTimer(writeLogDuration).ObserveDuration()
+	logger().Debug("writing game log", "username", gamelog.Username)
 	time.Sleep(writeToDiskSleep)
+	return AppendLog(logsFile, gamelog)
+}
 
-	f, err := os.OpenFile(logsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// AppendLog appends gamelog to the file at path, without the simulated disk
+// delay.
+func AppendLog(path string, gamelog routing.GameLog) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("could not open logs file: %v", err)
 	}
@@ -0,0 +1,72 @@
+package gamelogic
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	spawnsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "game",
+		Name:      "spawns_total",
+		Help:      "Units spawned, by rank.",
+	}, []string{"rank"})
+
+	movesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "game",
+		Name:      "moves_total",
+		Help:      "Army moves seen, by outcome.",
+	}, []string{"outcome"})
+
+	warsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "game",
+		Name:      "wars_total",
+		Help:      "War recognitions handled, by outcome.",
+	}, []string{"outcome"})
+
+	unitsKilledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "game",
+		Name:      "units_killed_total",
+		Help:      "Own units lost in wars, by rank.",
+	}, []string{"rank"})
+
+	writeLogDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "peril",
+		Subsystem: "game",
+		Name:      "write_log_duration_seconds",
+		Help:      "Time taken to write a game log to disk.",
+		Buckets:   prometheus.LinearBuckets(0.5, 0.25, 8),
+	})
+)
+
+func (o MoveOutcome) String() string {
+	switch o {
+	case MoveOutcomeSamePlayer:
+		return "same_player"
+	case MoveOutComeSafe:
+		return "safe"
+	case MoveOutcomeMakeWar:
+		return "make_war"
+	}
+	return "unknown"
+}
+
+func (o WarOutcome) String() string {
+	switch o {
+	case WarOutcomeNotInvolved:
+		return "not_involved"
+	case WarOutcomeNoUnits:
+		return "no_units"
+	case WarOutcomeYouWon:
+		return "you_won"
+	case WarOutcomeOpponentWon:
+		return "opponent_won"
+	case WarOutcomeDraw:
+		return "draw"
+	}
+	return "unknown"
+}
@@ -14,8 +14,18 @@ const (
 	MoveOutcomeMakeWar
 )
 
-func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
+func (gs *GameState) HandleMove(move ArmyMove) (outcome MoveOutcome) {
 	defer fmt.Println("------------------------")
+	defer func() {
+		movesTotal.WithLabelValues(outcome.String()).Inc()
+		logger().Info("move handled",
+			"username", gs.GetUsername(),
+			"mover", move.Player.Username,
+			"location", move.ToLocation,
+			"unit_ids", unitIDs(move.Units),
+			"outcome", outcome.String(),
+		)
+	}()
 	player := gs.GetPlayerSnap()
 
 	fmt.Println()
@@ -16,6 +16,15 @@ const (
 
 func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
 	defer fmt.Println("------------------------")
+	defer func() {
+		warsTotal.WithLabelValues(outcome.String()).Inc()
+		logger().Info("war handled",
+			"username", gs.GetUsername(),
+			"attacker", rw.Attacker.Username,
+			"defender", rw.Defender.Username,
+			"outcome", outcome.String(),
+		)
+	}()
 	fmt.Println()
 	fmt.Println("==== War Declared ====")
 	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
@@ -0,0 +1,137 @@
+// Package perilpb holds the protobuf form of the Peril wire messages and
+// converters to and from the structs the game uses internally.
+package perilpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative peril.proto
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Importing the package lets the protobuf codec encode and decode the plain
+// game structs, not just the generated types.
+func init() {
+	pubsub.RegisterProtoType(FromPlayingState, ToPlayingState)
+	pubsub.RegisterProtoType(FromGameLog, ToGameLog)
+	pubsub.RegisterProtoType(FromPlayer, ToPlayer)
+	pubsub.RegisterProtoType(FromArmyMove, ToArmyMove)
+	pubsub.RegisterProtoType(FromRecognitionOfWar, ToRecognitionOfWar)
+}
+
+func FromPlayingState(ps routing.PlayingState) *PlayingState {
+	return &PlayingState{IsPaused: ps.IsPaused}
+}
+
+func ToPlayingState(m *PlayingState) routing.PlayingState {
+	return routing.PlayingState{IsPaused: m.GetIsPaused()}
+}
+
+func FromGameLog(gl routing.GameLog) *GameLog {
+	return &GameLog{
+		CurrentTime: timestamppb.New(gl.CurrentTime),
+		Message:     gl.Message,
+		Username:    gl.Username,
+	}
+}
+
+func ToGameLog(m *GameLog) routing.GameLog {
+	gl := routing.GameLog{
+		Message:  m.GetMessage(),
+		Username: m.GetUsername(),
+	}
+	if m.GetCurrentTime() != nil {
+		gl.CurrentTime = m.GetCurrentTime().AsTime()
+	}
+	return gl
+}
+
+var ranksToProto = map[gamelogic.UnitRank]UnitRank{
+	gamelogic.RankInfantry:  UnitRank_UNIT_RANK_INFANTRY,
+	gamelogic.RankCavalry:   UnitRank_UNIT_RANK_CAVALRY,
+	gamelogic.RankArtillery: UnitRank_UNIT_RANK_ARTILLERY,
+}
+
+// FromUnit sends a rank the schema does not know as UNIT_RANK_UNSPECIFIED.
+func FromUnit(u gamelogic.Unit) *Unit {
+	return &Unit{
+		Id:       int32(u.ID),
+		Rank:     ranksToProto[u.Rank],
+		Location: string(u.Location),
+	}
+}
+
+// ToUnit leaves the rank empty for UNIT_RANK_UNSPECIFIED and for ranks added
+// to the schema after this build, rather than guessing one.
+func ToUnit(m *Unit) gamelogic.Unit {
+	u := gamelogic.Unit{
+		ID:       int(m.GetId()),
+		Location: gamelogic.Location(m.GetLocation()),
+	}
+	for rank, pbRank := range ranksToProto {
+		if pbRank == m.GetRank() {
+			u.Rank = rank
+		}
+	}
+	return u
+}
+
+func FromPlayer(p gamelogic.Player) *Player {
+	m := &Player{
+		Username: p.Username,
+		Units:    make(map[int32]*Unit, len(p.Units)),
+	}
+	for id, u := range p.Units {
+		m.Units[int32(id)] = FromUnit(u)
+	}
+	return m
+}
+
+func ToPlayer(m *Player) gamelogic.Player {
+	p := gamelogic.Player{
+		Username: m.GetUsername(),
+		Units:    make(map[int]gamelogic.Unit, len(m.GetUnits())),
+	}
+	for id, u := range m.GetUnits() {
+		p.Units[int(id)] = ToUnit(u)
+	}
+	return p
+}
+
+func FromArmyMove(mv gamelogic.ArmyMove) *ArmyMove {
+	m := &ArmyMove{
+		Player:     FromPlayer(mv.Player),
+		ToLocation: string(mv.ToLocation),
+	}
+	for _, u := range mv.Units {
+		m.Units = append(m.Units, FromUnit(u))
+	}
+	return m
+}
+
+func ToArmyMove(m *ArmyMove) gamelogic.ArmyMove {
+	mv := gamelogic.ArmyMove{
+		Player:     ToPlayer(m.GetPlayer()),
+		ToLocation: gamelogic.Location(m.GetToLocation()),
+	}
+	for _, u := range m.GetUnits() {
+		mv.Units = append(mv.Units, ToUnit(u))
+	}
+	return mv
+}
+
+func FromRecognitionOfWar(rw gamelogic.RecognitionOfWar) *RecognitionOfWar {
+	return &RecognitionOfWar{
+		Attacker: FromPlayer(rw.Attacker),
+		Defender: FromPlayer(rw.Defender),
+	}
+}
+
+func ToRecognitionOfWar(m *RecognitionOfWar) gamelogic.RecognitionOfWar {
+	return gamelogic.RecognitionOfWar{
+		Attacker: ToPlayer(m.GetAttacker()),
+		Defender: ToPlayer(m.GetDefender()),
+	}
+}
@@ -0,0 +1,158 @@
+package perilpb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/proto"
+)
+
+// roundTrip converts v to its generated message, through the wire format and
+// back.
+func roundTrip[T any, P proto.Message](t *testing.T, v T, from func(T) P, to func(P) T) T {
+	t.Helper()
+	data, err := proto.Marshal(from(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := from(v)
+	proto.Reset(m)
+	if err := proto.Unmarshal(data, m); err != nil {
+		t.Fatal(err)
+	}
+	return to(m)
+}
+
+var testPlayer = gamelogic.Player{
+	Username: "alice",
+	Units: map[int]gamelogic.Unit{
+		1: {ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: gamelogic.RankCavalry, Location: "asia"},
+		3: {ID: 3, Rank: gamelogic.RankArtillery, Location: "africa"},
+	},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, ps := range []routing.PlayingState{{IsPaused: true}, {IsPaused: false}} {
+		if got := roundTrip(t, ps, FromPlayingState, ToPlayingState); got != ps {
+			t.Errorf("PlayingState %+v came back as %+v", ps, got)
+		}
+	}
+
+	gl := routing.GameLog{CurrentTime: time.Date(2024, 5, 1, 12, 30, 0, 1234, time.UTC), Message: "alice won", Username: "alice"}
+	if got := roundTrip(t, gl, FromGameLog, ToGameLog); !got.CurrentTime.Equal(gl.CurrentTime) || got.Message != gl.Message || got.Username != gl.Username {
+		t.Errorf("GameLog %+v came back as %+v", gl, got)
+	}
+
+	if got := roundTrip(t, testPlayer, FromPlayer, ToPlayer); !reflect.DeepEqual(got, testPlayer) {
+		t.Errorf("Player %+v came back as %+v", testPlayer, got)
+	}
+
+	mv := gamelogic.ArmyMove{
+		Player:     testPlayer,
+		Units:      []gamelogic.Unit{testPlayer.Units[1], testPlayer.Units[3]},
+		ToLocation: "americas",
+	}
+	if got := roundTrip(t, mv, FromArmyMove, ToArmyMove); !reflect.DeepEqual(got, mv) {
+		t.Errorf("ArmyMove %+v came back as %+v", mv, got)
+	}
+
+	rw := gamelogic.RecognitionOfWar{
+		Attacker: testPlayer,
+		Defender: gamelogic.Player{Username: "bob", Units: map[int]gamelogic.Unit{}},
+	}
+	if got := roundTrip(t, rw, FromRecognitionOfWar, ToRecognitionOfWar); !reflect.DeepEqual(got, rw) {
+		t.Errorf("RecognitionOfWar %+v came back as %+v", rw, got)
+	}
+}
+
+func TestUnitRanks(t *testing.T) {
+	tests := []struct {
+		rank gamelogic.UnitRank
+		pb   UnitRank
+	}{
+		{gamelogic.RankInfantry, UnitRank_UNIT_RANK_INFANTRY},
+		{gamelogic.RankCavalry, UnitRank_UNIT_RANK_CAVALRY},
+		{gamelogic.RankArtillery, UnitRank_UNIT_RANK_ARTILLERY},
+		{"", UnitRank_UNIT_RANK_UNSPECIFIED},
+	}
+	for _, tt := range tests {
+		if got := FromUnit(gamelogic.Unit{Rank: tt.rank}).GetRank(); got != tt.pb {
+			t.Errorf("FromUnit rank %q = %v, want %v", tt.rank, got, tt.pb)
+		}
+		if got := ToUnit(&Unit{Rank: tt.pb}).Rank; got != tt.rank {
+			t.Errorf("ToUnit rank %v = %q, want %q", tt.pb, got, tt.rank)
+		}
+	}
+
+	if got := FromUnit(gamelogic.Unit{Rank: "dragoon"}).GetRank(); got != UnitRank_UNIT_RANK_UNSPECIFIED {
+		t.Errorf("unknown rank sent as %v, want UNIT_RANK_UNSPECIFIED", got)
+	}
+	if got := ToUnit(&Unit{Rank: UnitRank(42)}).Rank; got != "" {
+		t.Errorf("unknown proto rank read as %q, want no rank", got)
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	if gl := ToGameLog(&GameLog{Message: "no time"}); !gl.CurrentTime.IsZero() {
+		t.Errorf("GameLog without a timestamp has time %v, want zero", gl.CurrentTime)
+	}
+
+	mv := ToArmyMove(&ArmyMove{ToLocation: "europe"})
+	if mv.Player.Username != "" || len(mv.Player.Units) != 0 || mv.ToLocation != "europe" {
+		t.Errorf("ArmyMove without a player: %+v", mv)
+	}
+
+	rw := ToRecognitionOfWar(&RecognitionOfWar{Defender: FromPlayer(testPlayer)})
+	if rw.Attacker.Username != "" || rw.Attacker.Units == nil || !reflect.DeepEqual(rw.Defender, testPlayer) {
+		t.Errorf("RecognitionOfWar without an attacker: %+v", rw)
+	}
+	if rw := ToRecognitionOfWar(nil); rw.Attacker.Username != "" || rw.Defender.Username != "" {
+		t.Errorf("nil RecognitionOfWar: %+v", rw)
+	}
+}
+
+func TestPublishSubscribeProtobuf(t *testing.T) {
+	conn := pubsub.NewMemoryBroker().Connect()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	ch.ExchangeDeclare(routing.ExchangePerilTopic, amqp.ExchangeTopic, true, false, false, false, nil)
+
+	got := make(chan gamelogic.ArmyMove, 1)
+	contentTypes := make(chan string, 1)
+	sub, err := pubsub.SubscribeWithEnvelope(context.Background(), conn, routing.ExchangePerilTopic, "army_moves.bob", routing.ArmyMovesPrefix+".*", pubsub.Transient, func(mv gamelogic.ArmyMove, env pubsub.Envelope) pubsub.AckType {
+		contentTypes <- env.ContentType
+		got <- mv
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	mv := gamelogic.ArmyMove{Player: testPlayer, Units: []gamelogic.Unit{testPlayer.Units[2]}, ToLocation: "asia"}
+	err = pubsub.Publish(context.Background(), ch, routing.ExchangePerilTopic, routing.ArmyMovesPrefix+".alice", mv, pubsub.WithContentType(pubsub.ContentTypeProtobuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if !reflect.DeepEqual(m, mv) {
+			t.Errorf("received %+v, want %+v", m, mv)
+		}
+		if ct := <-contentTypes; ct != pubsub.ContentTypeProtobuf {
+			t.Errorf("content type %q, want %q", ct, pubsub.ContentTypeProtobuf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the army move")
+	}
+}
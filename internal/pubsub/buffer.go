@@ -0,0 +1,72 @@
+package pubsub
+
+import (
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// deliveryBuffer queues deliveries without bound, so a transport's network
+// loop never blocks on a slow handler. Deliveries come out of out in order
+// until close is called.
+type deliveryBuffer struct {
+	mu     sync.Mutex
+	buf    []amqp.Delivery
+	done   bool
+	signal chan struct{}
+	stop   chan struct{}
+	out    chan amqp.Delivery
+}
+
+func newDeliveryBuffer() *deliveryBuffer {
+	b := &deliveryBuffer{
+		signal: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		out:    make(chan amqp.Delivery),
+	}
+	go b.run()
+	return b
+}
+
+func (b *deliveryBuffer) push(d amqp.Delivery) {
+	b.mu.Lock()
+	b.buf = append(b.buf, d)
+	b.mu.Unlock()
+	select {
+	case b.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (b *deliveryBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.done {
+		b.done = true
+		close(b.stop)
+	}
+}
+
+func (b *deliveryBuffer) run() {
+	defer close(b.out)
+	for {
+		b.mu.Lock()
+		if len(b.buf) == 0 {
+			b.mu.Unlock()
+			select {
+			case <-b.signal:
+				continue
+			case <-b.stop:
+				return
+			}
+		}
+		d := b.buf[0]
+		b.buf = b.buf[1:]
+		b.mu.Unlock()
+		select {
+		case b.out <- d:
+		case <-b.stop:
+			return
+		}
+	}
+}
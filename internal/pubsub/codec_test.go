@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type codecMove struct {
+	Username string
+	Units    []int
+	At       time.Time
+}
+
+func TestSubscribeDecodesByContentType(t *testing.T) {
+	conn := newTestConnection(t, NewMemoryBroker())
+	if err := DeclareTopology(conn, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+	type decoded struct {
+		move        codecMove
+		contentType string
+	}
+	got := make(chan decoded, 4)
+	sub, err := SubscribeWithEnvelope(context.Background(), conn, routing.ExchangePerilTopic, "codecs", "codecs", Transient, func(m codecMove, env Envelope) AckType {
+		got <- decoded{m, env.ContentType}
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	want := codecMove{Username: "alice", Units: []int{1, 2, 3}, At: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	contentTypes := []string{ContentTypeJSON, ContentTypeGob, ContentTypeMsgPack, ContentTypeCBOR}
+	pub := conn.Publisher()
+	for _, ct := range contentTypes {
+		if err := Publish(context.Background(), pub, routing.ExchangePerilTopic, "codecs", want, WithContentType(ct)); err != nil {
+			t.Fatalf("%s: %v", ct, err)
+		}
+	}
+	for _, ct := range contentTypes {
+		select {
+		case d := <-got:
+			if d.contentType != ct {
+				t.Errorf("received %s, want %s next", d.contentType, ct)
+			}
+			if !d.move.At.Equal(want.At) || d.move.Username != want.Username || !reflect.DeepEqual(d.move.Units, want.Units) {
+				t.Errorf("%s decoded %+v, want %+v", d.contentType, d.move, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the %s message", ct)
+		}
+	}
+	if stats := sub.DecodeStats(); stats.Failures != 0 {
+		t.Errorf("DecodeStats = %+v, want no failures", stats)
+	}
+}
+
+func TestSubscribeUnknownContentType(t *testing.T) {
+	conn := newTestConnection(t, NewMemoryBroker())
+	if err := DeclareTopology(conn, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan codecMove, 1)
+	sub, err := Subscribe(context.Background(), conn, routing.ExchangePerilTopic, "codecs", "codecs", Transient, func(m codecMove) AckType {
+		handled <- m
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	pub := conn.Publisher()
+	err = pub.PublishWithContext(context.Background(), routing.ExchangePerilTopic, "codecs", false, false, amqp.Publishing{
+		ContentType: "application/x-yaml",
+		Body:        []byte("username: alice\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	PublishJSON(pub, routing.ExchangePerilTopic, "codecs", codecMove{Username: "bob"})
+	select {
+	case m := <-handled:
+		if m.Username != "bob" {
+			t.Fatalf("handled %+v, want bob's move", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the undecodable message blocked the queue")
+	}
+
+	if stats := sub.DecodeStats(); stats != (DecodeStats{Failures: 1, DeadLettered: 1}) {
+		t.Errorf("DecodeStats = %+v, want one dead-lettered failure", stats)
+	}
+	dls, err := InspectDeadLetters(conn, routing.DeadLetterQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].Reason != decodeFailedReason || !strings.Contains(dls[0].Error, "no codec registered") {
+		t.Fatalf("dead letters %+v, want the message without a codec", dls)
+	}
+}
@@ -0,0 +1,121 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultConfirmTimeout = 5 * time.Second
+
+var ErrNacked = errors.New("pubsub: message was nacked by the broker")
+
+// ErrUnconfirmed is reported when the channel closes after a message was sent
+// but before the broker confirmed it, so it may or may not have been routed.
+var ErrUnconfirmed = errors.New("pubsub: channel closed before the message was confirmed")
+
+// ReturnedError is reported when a mandatory message could not be routed to
+// any queue.
+type ReturnedError struct {
+	Return amqp.Return
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("pubsub: message returned by broker: %d %s (exchange %q, key %q)", e.Return.ReplyCode, e.Return.ReplyText, e.Return.Exchange, e.Return.RoutingKey)
+}
+
+// ConfirmingPublisher puts its channel in confirm mode and publishes every
+// message as mandatory, so a publish only succeeds once the broker has
+// routed and acknowledged it.
+type ConfirmingPublisher struct {
+	mu       sync.Mutex
+	ch       Channel
+	seq      uint64
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+func NewConfirmingPublisher(ch Channel) (*ConfirmingPublisher, error) {
+	err := ch.Confirm(false)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfirmingPublisher{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 16)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 16)),
+	}, nil
+}
+
+func (p *ConfirmingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultConfirmTimeout)
+		defer cancel()
+	}
+
+	// Anything still buffered belongs to a publish that already gave up.
+	p.drainReturns()
+
+	err := p.ch.PublishWithContext(ctx, exchange, key, true, immediate, msg)
+	if err != nil {
+		return err
+	}
+	p.seq++
+
+	var returned *amqp.Return
+	for {
+		select {
+		case r, ok := <-p.returns:
+			if !ok {
+				return ErrUnconfirmed
+			}
+			returned = &r
+		case c, ok := <-p.confirms:
+			if !ok {
+				return ErrUnconfirmed
+			}
+			if c.DeliveryTag < p.seq {
+				continue
+			}
+			if !c.Ack {
+				return ErrNacked
+			}
+			if returned == nil {
+				returned = p.drainReturns()
+			}
+			if returned != nil {
+				return &ReturnedError{Return: *returned}
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *ConfirmingPublisher) drainReturns() *amqp.Return {
+	var last *amqp.Return
+	for {
+		select {
+		case r, ok := <-p.returns:
+			if !ok {
+				return last
+			}
+			last = &r
+		default:
+			return last
+		}
+	}
+}
+
+func (p *ConfirmingPublisher) Close() error {
+	return p.ch.Close()
+}
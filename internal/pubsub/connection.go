@@ -0,0 +1,418 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrNotConnected = errors.New("pubsub: not connected to broker")
+
+// Dialer opens a raw broker connection. The returned Broker must support
+// NotifyClose so the managed Connection can detect when it goes away.
+type Dialer func() (Broker, error)
+
+type closeNotifier interface {
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+}
+
+// recoverer runs fn again on every new connection until the returned
+// function is called.
+type recoverer interface {
+	onRecover(fn func(Broker) error) (unregister func())
+}
+
+type recovery struct {
+	fn func(Broker) error
+}
+
+// Connection is a self-healing Broker. When the underlying connection is
+// lost it redials with exponential backoff, re-runs every registered
+// subscription and reopens publisher channels.
+type Connection struct {
+	dial           Dialer
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	onDisconnected func(err error)
+	onReconnecting func(attempt int, err error)
+	onReconnected  func()
+	amqpConfig     amqp.Config
+
+	mu         sync.Mutex
+	current    Broker
+	ready      chan struct{}
+	done       chan struct{}
+	closed     bool
+	recoveries []*recovery
+	publishers []*managedPublisher
+}
+
+type ConnectionOption func(*Connection)
+
+func WithBackoff(min, max time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+func OnDisconnected(fn func(err error)) ConnectionOption {
+	return func(c *Connection) {
+		c.onDisconnected = fn
+	}
+}
+
+func OnReconnecting(fn func(attempt int, err error)) ConnectionOption {
+	return func(c *Connection) {
+		c.onReconnecting = fn
+	}
+}
+
+func OnReconnected(fn func()) ConnectionOption {
+	return func(c *Connection) {
+		c.onReconnected = fn
+	}
+}
+
+// WithConnectionName sets the name the broker shows for this connection in
+// its management UI and logs. Over MQTT it is the client ID, which must be
+// unique among connected clients. STOMP connections cannot be named.
+func WithConnectionName(name string) ConnectionOption {
+	return func(c *Connection) {
+		c.amqpConfig.Properties.SetClientConnectionName(name)
+	}
+}
+
+func WithClientProperty(key string, value any) ConnectionOption {
+	return func(c *Connection) {
+		c.amqpConfig.Properties[key] = value
+	}
+}
+
+// WithExternalAuth authenticates with the SASL EXTERNAL mechanism, so the
+// broker takes the identity from the TLS client certificate and ignores any
+// credentials in the URL. Only AMQP supports it.
+func WithExternalAuth() ConnectionOption {
+	return func(c *Connection) {
+		c.amqpConfig.SASL = []amqp.Authentication{&amqp.ExternalAuth{}}
+	}
+}
+
+func Dial(url string, opts ...ConnectionOption) (*Connection, error) {
+	return DialTLS(url, nil, opts...)
+}
+
+// DialTLS is like Dial for amqps URLs, using cfg for the TLS handshake and
+// client certificates.
+func DialTLS(url string, cfg *tls.Config, opts ...ConnectionOption) (*Connection, error) {
+	c := newConnection(opts)
+	config := c.amqpConfig
+	config.TLSClientConfig = cfg
+	c.dial = func() (Broker, error) {
+		conn, err := amqp.DialConfig(url, config)
+		if err != nil {
+			return nil, err
+		}
+		return NewAMQPBroker(conn), nil
+	}
+	return c.start()
+}
+
+func NewConnection(dial Dialer, opts ...ConnectionOption) (*Connection, error) {
+	c := newConnection(opts)
+	c.dial = dial
+	return c.start()
+}
+
+func newConnection(opts []ConnectionOption) *Connection {
+	c := &Connection{
+		minBackoff:     500 * time.Millisecond,
+		maxBackoff:     30 * time.Second,
+		onDisconnected: func(error) {},
+		onReconnecting: func(int, error) {},
+		onReconnected:  func() {},
+		amqpConfig: amqp.Config{
+			Heartbeat:  10 * time.Second,
+			Locale:     "en_US",
+			Properties: amqp.NewConnectionProperties(),
+		},
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Connection) connectionName() string {
+	name, _ := c.amqpConfig.Properties["connection_name"].(string)
+	return name
+}
+
+func (c *Connection) externalAuth() bool {
+	return len(c.amqpConfig.SASL) > 0
+}
+
+func (c *Connection) start() (*Connection, error) {
+	b, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	notifier, ok := b.(closeNotifier)
+	if !ok {
+		b.Close()
+		return nil, errors.New("pubsub: dialled broker does not support NotifyClose")
+	}
+	c.current = b
+	close(c.ready)
+	go c.watch(notifier)
+	return c, nil
+}
+
+func (c *Connection) Channel() (Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return nil, ErrNotConnected
+	}
+	return c.current.Channel()
+}
+
+// Publisher returns a Publisher that transparently reopens its channel after
+// a reconnect.
+func (c *Connection) Publisher() Publisher {
+	return c.newPublisher(false)
+}
+
+// ConfirmingPublisher is like Publisher, but every channel it opens is
+// wrapped in a ConfirmingPublisher.
+func (c *Connection) ConfirmingPublisher() Publisher {
+	return c.newPublisher(true)
+}
+
+func (c *Connection) newPublisher(confirm bool) Publisher {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := &managedPublisher{conn: c, confirm: confirm}
+	c.publishers = append(c.publishers, p)
+	return p
+}
+
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	b := c.current
+	c.current = nil
+	c.mu.Unlock()
+
+	if b == nil {
+		return nil
+	}
+	return b.Close()
+}
+
+func (c *Connection) onRecover(fn func(Broker) error) func() {
+	r := &recovery{fn: fn}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recoveries = append(c.recoveries, r)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.recoveries = slices.DeleteFunc(c.recoveries, func(other *recovery) bool {
+			return other == r
+		})
+	}
+}
+
+func (c *Connection) waitReady(ctx context.Context) error {
+	c.mu.Lock()
+	ready := c.ready
+	c.mu.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-c.done:
+		return amqp.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Connection) watch(notifier closeNotifier) {
+	amqpErr := <-notifier.NotifyClose(make(chan *amqp.Error, 1))
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.current = nil
+	c.ready = make(chan struct{})
+	publishers := append([]*managedPublisher(nil), c.publishers...)
+	c.mu.Unlock()
+
+	for _, p := range publishers {
+		p.reset()
+	}
+
+	var cause error = ErrNotConnected
+	if amqpErr != nil {
+		cause = amqpErr
+	}
+	c.onDisconnected(cause)
+	c.reconnect(cause)
+}
+
+func (c *Connection) reconnect(cause error) {
+	delay := c.minBackoff
+	for attempt := 1; ; attempt++ {
+		c.onReconnecting(attempt, cause)
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+
+		b, err := c.dial()
+		if err == nil {
+			err = c.recover(b)
+		}
+		if err == nil {
+			return
+		}
+		logger().Warn("reconnect attempt failed", "attempt", attempt, "err", err)
+		cause = err
+		delay *= 2
+		if delay > c.maxBackoff {
+			delay = c.maxBackoff
+		}
+	}
+}
+
+func (c *Connection) recover(b Broker) error {
+	notifier, ok := b.(closeNotifier)
+	if !ok {
+		b.Close()
+		return errors.New("pubsub: dialled broker does not support NotifyClose")
+	}
+
+	c.mu.Lock()
+	recoveries := slices.Clone(c.recoveries)
+	c.mu.Unlock()
+	for _, r := range recoveries {
+		if err := r.fn(b); err != nil {
+			b.Close()
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		b.Close()
+		return nil
+	}
+	c.current = b
+	close(c.ready)
+	c.mu.Unlock()
+
+	go c.watch(notifier)
+	c.onReconnected()
+	return nil
+}
+
+type managedPublisher struct {
+	conn    *Connection
+	confirm bool
+	mu      sync.Mutex
+	ch      Channel
+	pub     Publisher
+}
+
+// PublishWithContext sends msg again on a fresh channel if the old one was
+// closed. A plain channel reports amqp.ErrClosed only when nothing was sent,
+// and a confirming one reports ErrUnconfirmed rather than amqp.ErrClosed once
+// the message is on the wire, so a message is never resent after the broker
+// may already have it.
+func (p *managedPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	for {
+		pub, err := p.publisher(ctx)
+		if err != nil {
+			return err
+		}
+		err = pub.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+		if !errors.Is(err, amqp.ErrClosed) {
+			return err
+		}
+		p.reset()
+	}
+}
+
+func (p *managedPublisher) publisher(ctx context.Context) (Publisher, error) {
+	for {
+		p.mu.Lock()
+		if p.pub != nil {
+			pub := p.pub
+			p.mu.Unlock()
+			return pub, nil
+		}
+		err := p.open()
+		if err == nil {
+			pub := p.pub
+			p.mu.Unlock()
+			return pub, nil
+		}
+		p.mu.Unlock()
+
+		if !errors.Is(err, ErrNotConnected) && !errors.Is(err, amqp.ErrClosed) {
+			return nil, err
+		}
+		if err := p.conn.waitReady(ctx); err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *managedPublisher) open() error {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return err
+	}
+	if !p.confirm {
+		p.ch, p.pub = ch, ch
+		return nil
+	}
+	pub, err := NewConfirmingPublisher(ch)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+	p.ch, p.pub = ch, pub
+	return nil
+}
+
+func (p *managedPublisher) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch != nil {
+		p.ch.Close()
+	}
+	p.ch, p.pub = nil, nil
+}
@@ -0,0 +1,72 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func newTestConnection(t *testing.T, b *MemoryBroker, opts ...ConnectionOption) *Connection {
+	t.Helper()
+	opts = append([]ConnectionOption{WithBackoff(time.Millisecond, 10*time.Millisecond)}, opts...)
+	conn, err := NewConnection(func() (Broker, error) {
+		return b.Connect(), nil
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestConnectionRecoversSubscriptions(t *testing.T) {
+	b := NewMemoryBroker()
+	reconnected := make(chan struct{}, 1)
+	conn := newTestConnection(t, b, OnReconnected(func() { reconnected <- struct{}{} }))
+	if err := DeclareTopology(conn, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string, 1)
+	sub, err := Subscribe(context.Background(), conn, "peril_topic", "q", "#", Transient, func(s string) AckType {
+		got <- s
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := Subscribe(context.Background(), conn, "peril_topic", "closed", "#", Transient, func(s string) AckType {
+		t.Errorf("closed subscription received %q", s)
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	if n := len(conn.recoveries); n != 2 {
+		t.Fatalf("%d recoveries registered, want the topology and one subscription", n)
+	}
+
+	b.Restart()
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("did not reconnect")
+	}
+
+	pub := conn.Publisher()
+	if err := PublishJSON(pub, "peril_topic", "k", "after restart"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-got:
+		if s != "after restart" {
+			t.Fatalf("got %q", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not recovered")
+	}
+	sub.Close()
+}
@@ -0,0 +1,150 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetter is a message parked in a dead-letter queue together with the
+// details of its most recent death taken from the x-death header.
+type DeadLetter struct {
+	Reason     string
+	Error      string
+	Queue      string
+	Exchange   string
+	RoutingKey string
+	Count      int64
+	Time       time.Time
+	Delivery   amqp.Delivery
+}
+
+func newDeadLetter(d amqp.Delivery) DeadLetter {
+	dl := DeadLetter{
+		Exchange:   d.Exchange,
+		RoutingKey: d.RoutingKey,
+		Delivery:   d,
+	}
+	deaths, _ := d.Headers["x-death"].([]interface{})
+	if len(deaths) == 0 {
+		return undecodableDeadLetter(dl)
+	}
+	death, ok := deaths[0].(amqp.Table)
+	if !ok {
+		return dl
+	}
+	dl.Reason, _ = death["reason"].(string)
+	dl.Queue, _ = death["queue"].(string)
+	dl.Exchange, _ = death["exchange"].(string)
+	dl.Count, _ = death["count"].(int64)
+	dl.Time, _ = death["time"].(time.Time)
+	if keys, ok := death["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+		dl.RoutingKey, _ = keys[0].(string)
+	}
+	return dl
+}
+
+// InspectDeadLetters returns every message in the dead-letter queue without
+// removing any of them.
+func InspectDeadLetters(b Broker, queue string) ([]DeadLetter, error) {
+	ch, err := b.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	var deadLetters []DeadLetter
+	var last *amqp.Delivery
+	for {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		last = &d
+		deadLetters = append(deadLetters, newDeadLetter(d))
+	}
+	if last != nil {
+		err = last.Nack(true, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deadLetters, nil
+}
+
+// RepublishDeadLetters moves every message in the dead-letter queue back to
+// the exchange and routing key it was originally published with. It stops
+// after the messages that were queued when it started, so anything that
+// dead-letters again straight away is left for the next run.
+func RepublishDeadLetters(ctx context.Context, b Broker, pub Publisher, queue string) (int, error) {
+	ch, err := b.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	n := 0
+	limit := -1
+	for limit < 0 || n < limit {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			return n, nil
+		}
+		if limit < 0 {
+			// MessageCount is what is left after this message.
+			limit = int(d.MessageCount) + 1
+		}
+
+		dl := newDeadLetter(d)
+		headers := cloneTable(d.Headers)
+		delete(headers, retryAttemptHeader)
+		for _, h := range undecodableHeaders {
+			delete(headers, h)
+		}
+		err = pub.PublishWithContext(ctx, dl.Exchange, dl.RoutingKey, false, false, publishingFrom(d, headers))
+		if err != nil {
+			d.Nack(false, true)
+			return n, err
+		}
+		err = d.Ack(false)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// publishingFrom copies d so it can be published again with headers.
+func publishingFrom(d amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+func PurgeDeadLetters(b Broker, queue string) (int, error) {
+	ch, err := b.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	return ch.QueuePurge(queue, false)
+}
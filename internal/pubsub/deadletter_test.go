@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestRepublishDeadLettersStops(t *testing.T) {
+	b := NewMemoryBroker()
+	conn := b.Connect()
+	if err := DeclareTopology(conn, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+	ch, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, "bounce", "bounce", Durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every message handed to this consumer goes straight back to the
+	// dead-letter queue.
+	deliveries, err := ch.Consume("bounce", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for d := range deliveries {
+			d.Nack(false, false)
+		}
+	}()
+
+	pub, _ := conn.Channel()
+	for i := 0; i < 3; i++ {
+		PublishJSON(pub, routing.ExchangePerilTopic, "bounce", i)
+	}
+	waitForDeadLetters(t, conn, 3)
+
+	n, err := RepublishDeadLetters(context.Background(), conn, pub, routing.DeadLetterQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("republished %d messages, want 3", n)
+	}
+	dls := waitForDeadLetters(t, conn, 3)
+	if dls[0].Queue != "bounce" || dls[0].RoutingKey != "bounce" {
+		t.Fatalf("dead letter from %q with key %q, want bounce", dls[0].Queue, dls[0].RoutingKey)
+	}
+}
+
+func waitForDeadLetters(t *testing.T, b Broker, n int) []DeadLetter {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		dls, err := InspectDeadLetters(b, routing.DeadLetterQueue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dls) == n {
+			return dls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d dead letters, want %d", len(dls), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,221 @@
+package pubsub
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupStore remembers which message IDs have already been handled.
+// Implementations must be safe for concurrent use.
+type DedupStore interface {
+	Seen(id string) (bool, error)
+	Mark(id string) error
+}
+
+type memoryDedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+// MemoryDedupStore is an LRU of message IDs. Entries expire after ttl and
+// the least recently marked ID is evicted once capacity is reached.
+type MemoryDedupStore struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryDedupStore) Seen(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(el.Value.(*memoryDedupEntry).expires) {
+		s.order.Remove(el)
+		delete(s.entries, id)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Mark(id string) error {
+	s.markUntil(id, time.Now().Add(s.ttl))
+	return nil
+}
+
+func (s *MemoryDedupStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryDedupStore) markUntil(id string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		el.Value.(*memoryDedupEntry).expires = expires
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[id] = s.order.PushFront(&memoryDedupEntry{id: id, expires: expires})
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryDedupEntry).id)
+	}
+}
+
+// minDedupCompactLines stops a small store rewriting its file on almost
+// every Mark.
+const minDedupCompactLines = 1024
+
+// FileDedupStore is a MemoryDedupStore backed by an append-only file, so
+// handled IDs survive a restart. The file is rewritten with only the live
+// entries when it is opened and whenever it grows to twice their number.
+type FileDedupStore struct {
+	*MemoryDedupStore
+	path  string
+	mu    sync.Mutex
+	file  *os.File
+	lines int
+}
+
+func NewFileDedupStore(path string, capacity int, ttl time.Duration) (*FileDedupStore, error) {
+	mem := NewMemoryDedupStore(capacity, ttl)
+	err := loadDedupFile(path, mem)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileDedupStore{MemoryDedupStore: mem, path: path}
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileDedupStore) Mark(id string) error {
+	expires := time.Now().Add(s.ttl)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.file, "%d %s\n", expires.UnixNano(), id)
+	if err != nil {
+		return err
+	}
+	s.markUntil(id, expires)
+	s.lines++
+	if s.lines >= max(2*s.len(), minDedupCompactLines) {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact rewrites the file with the live entries and reopens it for
+// appending. s.mu must be held, except while the store is being opened.
+func (s *FileDedupStore) compact() error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	n, err := compactDedupFile(s.path, s.MemoryDedupStore)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.file, s.lines = f, n
+	return nil
+}
+
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func loadDedupFile(path string, mem *MemoryDedupStore) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		nanos, id, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			continue
+		}
+		expires := time.Unix(0, n)
+		if expires.After(now) {
+			mem.markUntil(id, expires)
+		}
+	}
+	return scanner.Err()
+}
+
+// compactDedupFile replaces the file at path with the unexpired entries in
+// mem and returns how many it wrote.
+func compactDedupFile(path string, mem *MemoryDedupStore) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	n := 0
+	now := time.Now()
+	mem.mu.Lock()
+	for el := mem.order.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*memoryDedupEntry)
+		if e.expires.After(now) {
+			fmt.Fprintf(w, "%d %s\n", e.expires.UnixNano(), e.id)
+			n++
+		}
+	}
+	mem.mu.Unlock()
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, os.Rename(tmp, path)
+}
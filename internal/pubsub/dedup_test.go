@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestMemoryDedupStoreEvicts(t *testing.T) {
+	s := NewMemoryDedupStore(2, time.Hour)
+	for _, id := range []string{"a", "b", "c"} {
+		s.Mark(id)
+	}
+	for id, want := range map[string]bool{"a": false, "b": true, "c": true} {
+		if seen, _ := s.Seen(id); seen != want {
+			t.Errorf("Seen(%q) = %v, want %v", id, seen, want)
+		}
+	}
+
+	expiring := NewMemoryDedupStore(0, -time.Second)
+	expiring.Mark("a")
+	if seen, _ := expiring.Seen("a"); seen {
+		t.Error("expired ID is still seen")
+	}
+}
+
+func TestFileDedupStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	s, err := NewFileDedupStore(path, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		s.Mark(id)
+	}
+	s.Close()
+
+	s, err = NewFileDedupStore(path, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for id, want := range map[string]bool{"a": false, "b": true, "c": true} {
+		if seen, _ := s.Seen(id); seen != want {
+			t.Errorf("after reopen, Seen(%q) = %v, want %v", id, seen, want)
+		}
+	}
+}
+
+func TestFileDedupStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	s, err := NewFileDedupStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// Marking the same few IDs over and over must not grow the file
+	// without bound.
+	for i := 0; i < 5*minDedupCompactLines; i++ {
+		if err := s.Mark(string(rune('a' + i%10))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > minDedupCompactLines {
+		t.Fatalf("file has %d lines for 10 live IDs", lines)
+	}
+	if seen, _ := s.Seen("j"); !seen {
+		t.Fatal("compaction lost a live ID")
+	}
+}
+
+func TestSubscribeDeduplication(t *testing.T) {
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeTopic, true, false, false, false, nil)
+	var calls atomic.Int32
+	handled := make(chan struct{}, 10)
+	_, err := Subscribe(context.Background(), conn, "x", "q", "#", Durable, func(s string) AckType {
+		handled <- struct{}{}
+		// A requeued message has not been handled, so it must not be
+		// marked as seen.
+		if calls.Add(1) == 1 {
+			return NackRequeue
+		}
+		return Ack
+	}, WithDeduplication(NewMemoryDedupStore(10, time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		PublishJSON(ch, "x", "k", "hi", WithMessageID("m1"))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the handler")
+		}
+	}
+	select {
+	case <-handled:
+		t.Fatal("duplicate was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("handler ran %d times, want 2", n)
+	}
+}
@@ -0,0 +1,135 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	DefaultSchemaVersion = "1"
+
+	headerInstance      = "x-producer-instance"
+	headerSchemaVersion = "x-schema-version"
+	headerCausationID   = "x-causation-id"
+)
+
+// Envelope is the metadata stamped on every published message. The
+// correlation ID is shared by every message in a causal chain, while the
+// causation ID points at the message that directly triggered this one.
+type Envelope struct {
+	MessageID     string
+	CorrelationID string
+	CausationID   string
+	Producer      string
+	Instance      string
+	SentAt        time.Time
+	SchemaVersion string
+	ContentType   string
+	Exchange      string
+	RoutingKey    string
+	Queue         string
+	ReplyTo       string
+	Redelivered   bool
+	Headers       amqp.Table
+}
+
+var producer = struct {
+	sync.RWMutex
+	name     string
+	instance string
+}{
+	name:     filepath.Base(os.Args[0]),
+	instance: defaultInstance(),
+}
+
+func defaultInstance() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// SetProducer sets the producer name and instance stamped on every message
+// this process publishes. An empty instance keeps the hostname-pid default.
+func SetProducer(name, instance string) {
+	producer.Lock()
+	defer producer.Unlock()
+	producer.name = name
+	if instance != "" {
+		producer.instance = instance
+	}
+}
+
+func currentProducer() (string, string) {
+	producer.RLock()
+	defer producer.RUnlock()
+	return producer.name, producer.instance
+}
+
+// NewMessageID returns a random RFC 4122 version 4 UUID.
+func NewMessageID() string {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func stampEnvelope(msg *amqp.Publishing, options publishOptions) {
+	name, instance := currentProducer()
+	msg.MessageId = options.messageID
+	if msg.MessageId == "" {
+		msg.MessageId = NewMessageID()
+	}
+	msg.Timestamp = time.Now().UTC()
+	msg.AppId = name
+	msg.ReplyTo = options.replyTo
+	msg.Expiration = options.expiration
+	msg.Headers = amqp.Table{}
+	for k, v := range options.headers {
+		msg.Headers[k] = v
+	}
+	msg.Headers[headerInstance] = instance
+	msg.Headers[headerSchemaVersion] = options.schemaVersion
+
+	cause := options.causation
+	if cause == nil {
+		msg.CorrelationId = msg.MessageId
+		return
+	}
+	msg.CorrelationId = cause.CorrelationID
+	if msg.CorrelationId == "" {
+		msg.CorrelationId = cause.MessageID
+	}
+	msg.Headers[headerCausationID] = cause.MessageID
+}
+
+func newEnvelope(queue string, d amqp.Delivery) Envelope {
+	env := Envelope{
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		Producer:      d.AppId,
+		SentAt:        d.Timestamp,
+		ContentType:   d.ContentType,
+		Exchange:      d.Exchange,
+		RoutingKey:    d.RoutingKey,
+		Queue:         queue,
+		ReplyTo:       d.ReplyTo,
+		Redelivered:   d.Redelivered,
+		Headers:       d.Headers,
+	}
+	env.CausationID, _ = d.Headers[headerCausationID].(string)
+	env.Instance, _ = d.Headers[headerInstance].(string)
+	env.SchemaVersion, _ = d.Headers[headerSchemaVersion].(string)
+	return env
+}
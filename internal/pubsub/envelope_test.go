@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestEnvelopeCausation(t *testing.T) {
+	name, instance := currentProducer()
+	SetProducer("alice", "alice-1")
+	t.Cleanup(func() { SetProducer(name, instance) })
+
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeTopic, true, false, false, false, nil)
+	got := make(chan Envelope, 2)
+	_, err := SubscribeWithEnvelope(context.Background(), conn, "x", "q", "#", Durable, func(s string, env Envelope) AckType {
+		got <- env
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() Envelope {
+		t.Helper()
+		select {
+		case env := <-got:
+			return env
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a message")
+		}
+		return Envelope{}
+	}
+
+	PublishJSON(ch, "x", "first", "hi")
+	first := next()
+	if first.MessageID == "" || first.CorrelationID != first.MessageID || first.CausationID != "" {
+		t.Fatalf("first message: %+v, want its own ID as the correlation ID", first)
+	}
+	if first.Producer != "alice" || first.Instance != "alice-1" || first.SchemaVersion != DefaultSchemaVersion {
+		t.Fatalf("first message: %+v, want producer alice/alice-1", first)
+	}
+	if first.SentAt.IsZero() || first.RoutingKey != "first" || first.Queue != "q" || first.ContentType != ContentTypeJSON {
+		t.Fatalf("first message: %+v, want delivery metadata", first)
+	}
+
+	PublishGob(ch, "x", "second", "there", WithCausation(first))
+	second := next()
+	if second.CorrelationID != first.MessageID || second.CausationID != first.MessageID {
+		t.Fatalf("second message: %+v, want it caused by %s", second, first.MessageID)
+	}
+	if second.MessageID == first.MessageID || second.ContentType != ContentTypeGob {
+		t.Fatalf("second message: %+v, want a new gob message", second)
+	}
+}
@@ -0,0 +1,21 @@
+package pubsub
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+var pkgLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger used for diagnostics from this package. Until
+// it is called, slog.Default is used.
+func SetLogger(l *slog.Logger) {
+	pkgLogger.Store(l.With("component", "pubsub"))
+}
+
+func logger() *slog.Logger {
+	if l := pkgLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default().With("component", "pubsub")
+}
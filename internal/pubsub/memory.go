@@ -0,0 +1,1155 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is an in-process stand-in for RabbitMQ. It emulates direct,
+// topic and fanout exchanges, durable, transient and stream queues,
+// priorities, prefetch and ack/nack/requeue semantics closely enough to run
+// Peril without a server.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	exchanges map[string]*memoryExchange
+	queues    map[string]*memoryQueue
+	conns     map[*MemoryConnection]struct{}
+	nextID    uint64
+}
+
+type MemoryConnection struct {
+	broker   *MemoryBroker
+	channels map[*memoryChannel]struct{}
+	notify   []chan *amqp.Error
+	closed   bool
+}
+
+type memoryExchange struct {
+	name     string
+	kind     string
+	durable  bool
+	bindings []memoryBinding
+}
+
+type memoryBinding struct {
+	queue string
+	key   string
+}
+
+type memoryQueue struct {
+	name        string
+	durable     bool
+	autoDelete  bool
+	exclusive   bool
+	owner       *MemoryConnection
+	args        amqp.Table
+	messages    []*memoryMessage
+	consumers   []*memoryConsumer
+	next        int
+	hadConsumer bool
+	// stream queues keep every message in log, and each consumer reads
+	// from its own cursor.
+	stream bool
+	log    []*memoryMessage
+}
+
+type memoryMessage struct {
+	exchange    string
+	key         string
+	publishing  amqp.Publishing
+	redelivered bool
+	at          time.Time
+}
+
+type memoryChannel struct {
+	conn            *MemoryConnection
+	closed          bool
+	confirm         bool
+	publishSeq      uint64
+	notifyMu        sync.Mutex
+	confirms        []chan amqp.Confirmation
+	returns         []chan amqp.Return
+	prefetch        int
+	prefetchGlobal  int
+	nextTag         uint64
+	unacked         map[uint64]*memoryUnacked
+	consumers       map[string]*memoryConsumer
+	consumerCounter int
+	replyQueue      *memoryQueue
+}
+
+type memoryUnacked struct {
+	msg      *memoryMessage
+	queue    *memoryQueue
+	consumer *memoryConsumer
+}
+
+type memoryConsumer struct {
+	tag      string
+	ch       *memoryChannel
+	queue    *memoryQueue
+	autoAck  bool
+	prefetch int
+	inFlight int
+	cursor   int
+	buf      []amqp.Delivery
+	signal   chan struct{}
+	stop     chan struct{}
+	out      chan amqp.Delivery
+	done     bool
+	stopped  bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		exchanges: map[string]*memoryExchange{
+			"": {name: "", kind: amqp.ExchangeDirect, durable: true},
+		},
+		queues: map[string]*memoryQueue{},
+		conns:  map[*MemoryConnection]struct{}{},
+	}
+}
+
+func (b *MemoryBroker) Connect() *MemoryConnection {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	conn := &MemoryConnection{
+		broker:   b,
+		channels: map[*memoryChannel]struct{}{},
+	}
+	b.conns[conn] = struct{}{}
+	return conn
+}
+
+// Restart simulates a broker restart: every connection is dropped, transient
+// exchanges and queues are deleted and only persistent messages in durable
+// queues survive.
+func (b *MemoryBroker) Restart() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn := range b.conns {
+		conn.closeLocked(&amqp.Error{
+			Code:    amqp.ConnectionForced,
+			Reason:  "CONNECTION_FORCED - broker forced connection closure with reason 'shutdown'",
+			Server:  true,
+			Recover: true,
+		})
+	}
+	for name, ex := range b.exchanges {
+		if !ex.durable {
+			delete(b.exchanges, name)
+		}
+	}
+	for _, q := range b.queues {
+		if !q.durable {
+			b.deleteQueueLocked(q)
+			continue
+		}
+		kept := q.messages[:0]
+		for _, m := range q.messages {
+			if m.publishing.DeliveryMode == amqp.Persistent {
+				kept = append(kept, m)
+			}
+		}
+		q.messages = kept
+	}
+}
+
+func (b *MemoryBroker) genName(prefix string) string {
+	b.nextID++
+	return fmt.Sprintf("%s%d", prefix, b.nextID)
+}
+
+func (c *MemoryConnection) Channel() (Channel, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &memoryChannel{
+		conn:      c,
+		unacked:   map[uint64]*memoryUnacked{},
+		consumers: map[string]*memoryConsumer{},
+	}
+	c.channels[ch] = struct{}{}
+	return ch, nil
+}
+
+func (c *MemoryConnection) Close() error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.closeLocked(nil)
+	return nil
+}
+
+func (c *MemoryConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *MemoryConnection) closeLocked(err *amqp.Error) {
+	b := c.broker
+	for ch := range c.channels {
+		ch.closeLocked()
+	}
+	for _, q := range b.queues {
+		if q.exclusive && q.owner == c {
+			b.deleteQueueLocked(q)
+		}
+	}
+	c.closed = true
+	delete(b.conns, c)
+	for _, receiver := range c.notify {
+		if err != nil {
+			select {
+			case receiver <- err:
+			default:
+			}
+		}
+		close(receiver)
+	}
+	c.notify = nil
+}
+
+func (ch *memoryChannel) broker() *MemoryBroker {
+	return ch.conn.broker
+}
+
+func (ch *memoryChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b := ch.broker()
+	b.mu.Lock()
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	if _, ok := b.exchanges[exchange]; !ok {
+		b.mu.Unlock()
+		return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange)}
+	}
+	if msg.ReplyTo == directReplyTo {
+		if ch.replyQueue == nil {
+			b.mu.Unlock()
+			return &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - fast reply consumer does not exist"}
+		}
+		msg.ReplyTo = ch.replyQueue.name
+	}
+	targets := b.routeLocked(exchange, key, msg)
+	confirm := ch.confirm
+	if confirm {
+		ch.publishSeq++
+	}
+	seq := ch.publishSeq
+	ch.notifyMu.Lock()
+	b.mu.Unlock()
+	defer ch.notifyMu.Unlock()
+
+	// Like RabbitMQ, a basic.return is always sent before the publisher
+	// confirm of the same message.
+	if mandatory && len(targets) == 0 {
+		ret := amqp.Return{
+			ReplyCode:       amqp.NoRoute,
+			ReplyText:       "NO_ROUTE",
+			Exchange:        exchange,
+			RoutingKey:      key,
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
+			Headers:         msg.Headers,
+			DeliveryMode:    msg.DeliveryMode,
+			Priority:        msg.Priority,
+			CorrelationId:   msg.CorrelationId,
+			ReplyTo:         msg.ReplyTo,
+			Expiration:      msg.Expiration,
+			MessageId:       msg.MessageId,
+			Timestamp:       msg.Timestamp,
+			Type:            msg.Type,
+			UserId:          msg.UserId,
+			AppId:           msg.AppId,
+			Body:            msg.Body,
+		}
+		for _, receiver := range ch.returns {
+			receiver <- ret
+		}
+	}
+	if confirm {
+		for _, receiver := range ch.confirms {
+			receiver <- amqp.Confirmation{DeliveryTag: seq, Ack: true}
+		}
+	}
+	return nil
+}
+
+func (ch *memoryChannel) Confirm(noWait bool) error {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.confirm = true
+	return nil
+}
+
+func (ch *memoryChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+	ch.confirms = append(ch.confirms, confirm)
+	return confirm
+}
+
+func (ch *memoryChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+	ch.returns = append(ch.returns, c)
+	return c
+}
+
+func (ch *memoryChannel) closeNotifications() {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+	for _, c := range ch.confirms {
+		close(c)
+	}
+	for _, c := range ch.returns {
+		close(c)
+	}
+	ch.confirms = nil
+	ch.returns = nil
+}
+
+func (ch *memoryChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout:
+	default:
+		return &amqp.Error{Code: amqp.CommandInvalid, Reason: fmt.Sprintf("COMMAND_INVALID - unknown exchange type '%s'", kind)}
+	}
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind || ex.durable != durable {
+			return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for exchange '%s'", name)}
+		}
+		return nil
+	}
+	b.exchanges[name] = &memoryExchange{name: name, kind: kind, durable: durable}
+	return nil
+}
+
+func (ch *memoryChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	if name == "" {
+		name = b.genName("amq.gen-")
+	}
+	if q, ok := b.queues[name]; ok {
+		if q.exclusive && q.owner != ch.conn {
+			return amqp.Queue{}, &amqp.Error{Code: amqp.ResourceLocked, Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", name)}
+		}
+		if q.durable != durable || q.autoDelete != autoDelete || q.exclusive != exclusive {
+			return amqp.Queue{}, &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for queue '%s'", name)}
+		}
+		if key, ok := inequivalentArg(q.args, args); !ok {
+			return amqp.Queue{}, &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg '%s' for queue '%s'", key, name)}
+		}
+		return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: len(q.consumers)}, nil
+	}
+
+	q := &memoryQueue{
+		name:       name,
+		durable:    durable,
+		autoDelete: autoDelete,
+		exclusive:  exclusive,
+		args:       args,
+		stream:     args["x-queue-type"] == queueTypeStream,
+	}
+	if q.stream && (!durable || autoDelete || exclusive) {
+		return amqp.Queue{}, &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - invalid property for stream queue '%s'", name)}
+	}
+	if exclusive {
+		q.owner = ch.conn
+	}
+	b.queues[name] = q
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *memoryChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ex, ok := b.exchanges[exchange]
+	if !ok || exchange == "" {
+		return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange)}
+	}
+	if _, ok := b.queues[name]; !ok {
+		return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", name)}
+	}
+	for _, binding := range ex.bindings {
+		if binding.queue == name && binding.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memoryBinding{queue: name, key: key})
+	return nil
+}
+
+func (ch *memoryChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if global {
+		ch.prefetchGlobal = prefetchCount
+	} else {
+		ch.prefetch = prefetchCount
+	}
+	return nil
+}
+
+func (ch *memoryChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	q, ok := b.queues[queue]
+	if queue == directReplyTo {
+		q, ok = ch.replyQueueLocked(autoAck)
+		if q == nil {
+			return nil, &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - reply consumer cannot acknowledge"}
+		}
+	}
+	if !ok {
+		return nil, &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", queue)}
+	}
+	if q.exclusive && q.owner != ch.conn {
+		return nil, &amqp.Error{Code: amqp.ResourceLocked, Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", queue)}
+	}
+	if q.stream && (autoAck || ch.prefetch == 0) {
+		return nil, &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - stream consumers must use manual acks and set a prefetch count"}
+	}
+	if consumer == "" {
+		ch.consumerCounter++
+		consumer = fmt.Sprintf("ctag-memory-%d", ch.consumerCounter)
+	}
+	if _, ok := ch.consumers[consumer]; ok {
+		return nil, &amqp.Error{Code: amqp.NotAllowed, Reason: fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", consumer)}
+	}
+
+	c := &memoryConsumer{
+		tag:      consumer,
+		ch:       ch,
+		queue:    q,
+		autoAck:  autoAck,
+		prefetch: ch.prefetch,
+		signal:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		out:      make(chan amqp.Delivery),
+	}
+	if q.stream {
+		c.cursor = q.streamCursor(args[headerStreamOffset])
+	}
+	ch.consumers[consumer] = c
+	q.consumers = append(q.consumers, c)
+	q.hadConsumer = true
+	go c.pump(b)
+	q.dispatchLocked()
+	return c.out, nil
+}
+
+// replyQueueLocked emulates direct reply-to with a hidden queue per channel
+// that is deleted along with its consumer.
+func (ch *memoryChannel) replyQueueLocked(autoAck bool) (*memoryQueue, bool) {
+	if !autoAck {
+		return nil, false
+	}
+	b := ch.broker()
+	if ch.replyQueue == nil || b.queues[ch.replyQueue.name] != ch.replyQueue {
+		name := b.genName(directReplyTo + ".")
+		ch.replyQueue = &memoryQueue{name: name, autoDelete: true, exclusive: true, owner: ch.conn}
+		b.queues[name] = ch.replyQueue
+	}
+	return ch.replyQueue, true
+}
+
+func (ch *memoryChannel) Cancel(consumer string, noWait bool) error {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	c, ok := ch.consumers[consumer]
+	if !ok {
+		return nil
+	}
+	b.cancelConsumerLocked(c, false)
+	return nil
+}
+
+func (ch *memoryChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.Delivery{}, false, amqp.ErrClosed
+	}
+	q, ok := b.queues[queue]
+	if !ok {
+		return amqp.Delivery{}, false, &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", queue)}
+	}
+	if q.stream {
+		return amqp.Delivery{}, false, streamUnsupported(queue, "basic.get")
+	}
+	if len(q.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+
+	m := q.messages[0]
+	q.messages = q.messages[1:]
+	ch.nextTag++
+	if !autoAck {
+		ch.unacked[ch.nextTag] = &memoryUnacked{msg: m, queue: q}
+	}
+	d := newMemoryDelivery(ch, m, ch.nextTag, "")
+	d.MessageCount = uint32(len(q.messages))
+	return d, true, nil
+}
+
+func (ch *memoryChannel) QueuePurge(name string, noWait bool) (int, error) {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return 0, amqp.ErrClosed
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		return 0, &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", name)}
+	}
+	if q.stream {
+		return 0, streamUnsupported(name, "queue.purge")
+	}
+	n := len(q.messages)
+	q.messages = nil
+	return n, nil
+}
+
+// QueueDelete of a queue that does not exist succeeds, as it does in
+// RabbitMQ.
+func (ch *memoryChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return 0, amqp.ErrClosed
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		return 0, nil
+	}
+	if q.exclusive && q.owner != ch.conn {
+		return 0, &amqp.Error{Code: amqp.ResourceLocked, Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", name)}
+	}
+	if ifUnused && len(q.consumers) > 0 {
+		return 0, &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - queue '%s' in use", name)}
+	}
+	n := len(q.messages) + len(q.log)
+	if ifEmpty && n > 0 {
+		return 0, &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - queue '%s' not empty", name)}
+	}
+	b.deleteQueueLocked(q)
+	return n, nil
+}
+
+func (ch *memoryChannel) Close() error {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.closeLocked()
+	return nil
+}
+
+func (ch *memoryChannel) closeLocked() {
+	b := ch.broker()
+	for _, c := range ch.consumers {
+		b.cancelConsumerLocked(c, true)
+	}
+	tags := make([]uint64, 0, len(ch.unacked))
+	for tag := range ch.unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	touched := map[*memoryQueue]struct{}{}
+	for _, tag := range tags {
+		u := ch.unacked[tag]
+		delete(ch.unacked, tag)
+		u.queue.requeueLocked(u.msg)
+		touched[u.queue] = struct{}{}
+	}
+	ch.closed = true
+	delete(ch.conn.channels, ch)
+	for q := range touched {
+		q.dispatchLocked()
+	}
+	go ch.closeNotifications()
+}
+
+func (ch *memoryChannel) Ack(tag uint64, multiple bool) error {
+	return ch.settle(tag, multiple, func(u *memoryUnacked) {})
+}
+
+func (ch *memoryChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	b := ch.broker()
+	return ch.settle(tag, multiple, func(u *memoryUnacked) {
+		if requeue {
+			u.queue.requeueLocked(u.msg)
+			return
+		}
+		b.deadLetterLocked(u.queue, u.msg, "rejected")
+	})
+}
+
+func (ch *memoryChannel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+func (ch *memoryChannel) settle(tag uint64, multiple bool, fn func(u *memoryUnacked)) error {
+	b := ch.broker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	tags := []uint64{tag}
+	if multiple {
+		tags = tags[:0]
+		for t := range ch.unacked {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	} else if _, ok := ch.unacked[tag]; !ok {
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag)}
+	}
+
+	touched := map[*memoryQueue]struct{}{}
+	for _, t := range tags {
+		u := ch.unacked[t]
+		delete(ch.unacked, t)
+		if u.consumer != nil {
+			u.consumer.inFlight--
+		}
+		fn(u)
+		touched[u.queue] = struct{}{}
+	}
+	for q := range touched {
+		q.dispatchLocked()
+	}
+	return nil
+}
+
+func (b *MemoryBroker) routeLocked(exchange, key string, msg amqp.Publishing) []*memoryQueue {
+	ex := b.exchanges[exchange]
+	var targets []*memoryQueue
+	seen := map[string]struct{}{}
+	add := func(name string) {
+		if _, ok := seen[name]; ok {
+			return
+		}
+		if q, ok := b.queues[name]; ok {
+			seen[name] = struct{}{}
+			targets = append(targets, q)
+		}
+	}
+
+	if exchange == "" {
+		add(key)
+	}
+	for _, binding := range ex.bindings {
+		switch ex.kind {
+		case amqp.ExchangeDirect:
+			if binding.key == key {
+				add(binding.queue)
+			}
+		case amqp.ExchangeTopic:
+			if topicMatch(binding.key, key) {
+				add(binding.queue)
+			}
+		case amqp.ExchangeFanout:
+			add(binding.queue)
+		}
+	}
+
+	for _, q := range targets {
+		m := &memoryMessage{exchange: exchange, key: key, publishing: msg}
+		m.publishing.Headers = cloneTable(msg.Headers)
+		if q.stream {
+			if m.publishing.Headers == nil {
+				m.publishing.Headers = amqp.Table{}
+			}
+			m.publishing.Headers[headerStreamOffset] = int64(len(q.log))
+			m.at = time.Now()
+			q.log = append(q.log, m)
+			q.dispatchLocked()
+			continue
+		}
+		q.enqueueLocked(m)
+		b.expireLaterLocked(q, m)
+		q.dispatchLocked()
+	}
+	return targets
+}
+
+// expireLaterLocked dead-letters m if it is still waiting in q once the
+// queue's x-message-ttl or the message's own expiration has passed.
+func (b *MemoryBroker) expireLaterLocked(q *memoryQueue, m *memoryMessage) {
+	ttl, ok := tableInt(q.args, "x-message-ttl")
+	if exp, err := strconv.ParseInt(m.publishing.Expiration, 10, 64); err == nil && (!ok || exp < ttl) {
+		ttl, ok = exp, true
+	}
+	if !ok {
+		return
+	}
+
+	time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.queues[q.name] != q {
+			return
+		}
+		for i, other := range q.messages {
+			if other == m {
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				b.deadLetterLocked(q, m, "expired")
+				return
+			}
+		}
+	})
+}
+
+func (b *MemoryBroker) deadLetterLocked(q *memoryQueue, m *memoryMessage, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	if _, ok := b.exchanges[dlx]; !ok {
+		return
+	}
+	key := m.key
+	if dlk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlk
+	}
+
+	msg := m.publishing
+	msg.Expiration = ""
+	msg.Headers = cloneTable(msg.Headers)
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	death := amqp.Table{
+		"count":        int64(1),
+		"reason":       reason,
+		"queue":        q.name,
+		"time":         time.Now(),
+		"exchange":     m.exchange,
+		"routing-keys": []interface{}{m.key},
+	}
+	deaths, _ := msg.Headers["x-death"].([]interface{})
+	rest := make([]interface{}, 0, len(deaths)+1)
+	for _, d := range deaths {
+		t, ok := d.(amqp.Table)
+		if ok && t["queue"] == q.name && t["reason"] == reason {
+			count, _ := t["count"].(int64)
+			death["count"] = count + 1
+			continue
+		}
+		rest = append(rest, d)
+	}
+	msg.Headers["x-death"] = append([]interface{}{death}, rest...)
+	if _, ok := msg.Headers["x-first-death-reason"]; !ok {
+		msg.Headers["x-first-death-reason"] = reason
+		msg.Headers["x-first-death-queue"] = q.name
+		msg.Headers["x-first-death-exchange"] = m.exchange
+	}
+	b.routeLocked(dlx, key, msg)
+}
+
+// cancelConsumerLocked removes a consumer from its queue. A cancelled
+// consumer still hands out what it has buffered, like amqp091 does, unless
+// stop is set because its channel or queue went away.
+func (b *MemoryBroker) cancelConsumerLocked(c *memoryConsumer, stop bool) {
+	if stop && !c.stopped {
+		c.stopped = true
+		close(c.stop)
+	}
+	if c.done {
+		return
+	}
+	c.done = true
+	delete(c.ch.consumers, c.tag)
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+
+	q := c.queue
+	for i, other := range q.consumers {
+		if other == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.autoDelete && q.hadConsumer && len(q.consumers) == 0 {
+		b.deleteQueueLocked(q)
+	}
+}
+
+func (b *MemoryBroker) deleteQueueLocked(q *memoryQueue) {
+	for _, c := range append([]*memoryConsumer(nil), q.consumers...) {
+		b.cancelConsumerLocked(c, true)
+	}
+	for _, ex := range b.exchanges {
+		kept := ex.bindings[:0]
+		for _, binding := range ex.bindings {
+			if binding.queue != q.name {
+				kept = append(kept, binding)
+			}
+		}
+		ex.bindings = kept
+	}
+	if b.queues[q.name] == q {
+		delete(b.queues, q.name)
+	}
+}
+
+func (q *memoryQueue) dispatchLocked() {
+	if q.stream {
+		for _, c := range q.consumers {
+			for c.cursor < len(q.log) && c.hasCapacity() {
+				c.deliverLocked(q.log[c.cursor])
+				c.cursor++
+			}
+		}
+		return
+	}
+	for len(q.messages) > 0 {
+		c := q.nextConsumer()
+		if c == nil {
+			return
+		}
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		c.deliverLocked(m)
+	}
+}
+
+// enqueueLocked adds m behind every message of the same or a higher
+// priority, which on a queue without x-max-priority is the back.
+func (q *memoryQueue) enqueueLocked(m *memoryMessage) {
+	p := q.priority(m)
+	i := len(q.messages)
+	for i > 0 && q.priority(q.messages[i-1]) < p {
+		i--
+	}
+	q.messages = slices.Insert(q.messages, i, m)
+}
+
+// requeueLocked puts m back at the head of its priority in q. Stream
+// consumers never get messages back, since the stream still holds them.
+func (q *memoryQueue) requeueLocked(m *memoryMessage) {
+	if q.stream {
+		return
+	}
+	m.redelivered = true
+	p := q.priority(m)
+	i := 0
+	for i < len(q.messages) && q.priority(q.messages[i]) > p {
+		i++
+	}
+	q.messages = slices.Insert(q.messages, i, m)
+}
+
+// priority is m's priority, capped at the queue's x-max-priority. Queues
+// without it treat every message alike.
+func (q *memoryQueue) priority(m *memoryMessage) int64 {
+	limit, ok := tableInt(q.args, "x-max-priority")
+	if !ok {
+		return 0
+	}
+	return min(int64(m.publishing.Priority), limit)
+}
+
+// streamCursor resolves an x-stream-offset consumer argument to an index
+// into q.log.
+func (q *memoryQueue) streamCursor(offset any) int {
+	switch v := offset.(type) {
+	case string:
+		switch v {
+		case "first":
+			return 0
+		case "last":
+			return max(len(q.log)-1, 0)
+		}
+	case time.Time:
+		for i, m := range q.log {
+			if !m.at.Before(v) {
+				return i
+			}
+		}
+	case int64:
+		return min(max(int(v), 0), len(q.log))
+	}
+	return len(q.log)
+}
+
+func streamUnsupported(queue, method string) error {
+	return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - %s not supported by stream queue '%s'", method, queue)}
+}
+
+func (q *memoryQueue) nextConsumer() *memoryConsumer {
+	for i := 0; i < len(q.consumers); i++ {
+		c := q.consumers[(q.next+i)%len(q.consumers)]
+		if c.hasCapacity() {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *memoryConsumer) hasCapacity() bool {
+	if c.done || c.ch.closed {
+		return false
+	}
+	if c.autoAck {
+		return true
+	}
+	if c.prefetch > 0 && c.inFlight >= c.prefetch {
+		return false
+	}
+	if c.ch.prefetchGlobal > 0 && len(c.ch.unacked) >= c.ch.prefetchGlobal {
+		return false
+	}
+	return true
+}
+
+func (c *memoryConsumer) deliverLocked(m *memoryMessage) {
+	ch := c.ch
+	ch.nextTag++
+	tag := ch.nextTag
+	if !c.autoAck {
+		ch.unacked[tag] = &memoryUnacked{msg: m, queue: c.queue, consumer: c}
+		c.inFlight++
+	}
+
+	c.buf = append(c.buf, newMemoryDelivery(ch, m, tag, c.tag))
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+func newMemoryDelivery(ch *memoryChannel, m *memoryMessage, tag uint64, consumerTag string) amqp.Delivery {
+	p := m.publishing
+	return amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		ConsumerTag:     consumerTag,
+		DeliveryTag:     tag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.key,
+		Body:            p.Body,
+	}
+}
+
+// pump hands buffered deliveries to the consumer without holding the broker
+// lock, so a slow handler never blocks publishers.
+func (c *memoryConsumer) pump(b *MemoryBroker) {
+	defer close(c.out)
+	for {
+		b.mu.Lock()
+		if c.stopped {
+			c.requeueBufLocked(b)
+			b.mu.Unlock()
+			return
+		}
+		if len(c.buf) == 0 {
+			done := c.done
+			b.mu.Unlock()
+			if done {
+				return
+			}
+			select {
+			case <-c.signal:
+			case <-c.stop:
+			}
+			continue
+		}
+		d := c.buf[0]
+		c.buf = c.buf[1:]
+		b.mu.Unlock()
+
+		select {
+		case c.out <- d:
+		case <-c.stop:
+			b.mu.Lock()
+			c.buf = append([]amqp.Delivery{d}, c.buf...)
+			c.requeueBufLocked(b)
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (c *memoryConsumer) requeueBufLocked(b *MemoryBroker) {
+	ch := c.ch
+	for i := len(c.buf) - 1; i >= 0; i-- {
+		u, ok := ch.unacked[c.buf[i].DeliveryTag]
+		if !ok {
+			continue
+		}
+		delete(ch.unacked, c.buf[i].DeliveryTag)
+		c.inFlight--
+		if _, ok := b.queues[u.queue.name]; ok {
+			u.queue.requeueLocked(u.msg)
+		}
+	}
+	c.buf = nil
+	c.queue.dispatchLocked()
+}
+
+// equivalentArgs are the queue arguments RabbitMQ compares when a queue is
+// redeclared.
+var equivalentArgs = []string{
+	"x-queue-type",
+	"x-queue-mode",
+	"x-max-priority",
+	"x-max-length",
+	"x-max-length-bytes",
+	"x-max-age",
+	"x-overflow",
+	"x-message-ttl",
+	"x-expires",
+	"x-dead-letter-exchange",
+	"x-dead-letter-routing-key",
+	"x-single-active-consumer",
+}
+
+// inequivalentArg returns the first argument that differs between a queue's
+// declared args and a redeclaration, with a missing x-queue-type meaning
+// classic.
+func inequivalentArg(current, received amqp.Table) (string, bool) {
+	for _, key := range equivalentArgs {
+		a, b := current[key], received[key]
+		if key == "x-queue-type" {
+			if a == nil {
+				a = string(QueueClassic)
+			}
+			if b == nil {
+				b = string(QueueClassic)
+			}
+		}
+		if a == nil && b == nil {
+			continue
+		}
+		if x, ok := tableInt(current, key); ok {
+			if y, ok := tableInt(received, key); ok && x == y {
+				continue
+			}
+			return key, false
+		}
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			return key, false
+		}
+	}
+	return "", true
+}
+
+// topicMatch reports whether a topic routing key matches a binding pattern,
+// where "*" matches exactly one word and "#" matches zero or more words.
+func topicMatch(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && key[0] == pattern[0] && matchWords(pattern[1:], key[1:])
+	}
+}
+
+func cloneTable(t amqp.Table) amqp.Table {
+	if t == nil {
+		return nil
+	}
+	clone := make(amqp.Table, len(t))
+	for k, v := range t {
+		clone[k] = v
+	}
+	return clone
+}
@@ -0,0 +1,261 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"army_moves.*", "army_moves.alice", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.alice.bob", false},
+		{"war.#", "war", true},
+		{"war.#", "war.alice.bob", true},
+		{"#", "game_logs.alice", true},
+		{"#.alice", "game_logs.alice", true},
+		{"*.alice", "game_logs.bob", false},
+		{"pause", "pause", true},
+		{"pause", "paused", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func newTestChannel(t *testing.T, b *MemoryBroker) (*MemoryConnection, Channel) {
+	t.Helper()
+	conn := b.Connect()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, ch
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a delivery")
+	}
+	return amqp.Delivery{}
+}
+
+func expectNone(t *testing.T, deliveries <-chan amqp.Delivery) {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery %q", d.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryBrokerRestart(t *testing.T) {
+	b := NewMemoryBroker()
+	_, ch := newTestChannel(t, b)
+	ch.ExchangeDeclare("durable", amqp.ExchangeTopic, true, false, false, false, nil)
+	ch.ExchangeDeclare("transient", amqp.ExchangeTopic, false, false, false, false, nil)
+	ch.QueueDeclare("kept", true, false, false, false, nil)
+	ch.QueueDeclare("dropped", false, false, false, false, nil)
+	ch.QueueBind("kept", "#", "durable", false, nil)
+	ch.QueueBind("dropped", "#", "durable", false, nil)
+
+	ctx := context.Background()
+	ch.PublishWithContext(ctx, "durable", "k", false, false, amqp.Publishing{Body: []byte("persistent"), DeliveryMode: amqp.Persistent})
+	ch.PublishWithContext(ctx, "durable", "k", false, false, amqp.Publishing{Body: []byte("transient")})
+
+	b.Restart()
+
+	if _, _, err := ch.Get("kept", true); err == nil {
+		t.Fatal("channel survived the restart")
+	}
+	_, ch = newTestChannel(t, b)
+	d, ok, err := ch.Get("kept", true)
+	if err != nil || !ok || string(d.Body) != "persistent" {
+		t.Fatalf("Get = %q, %v, %v, want the persistent message", d.Body, ok, err)
+	}
+	if _, ok, _ := ch.Get("kept", true); ok {
+		t.Fatal("transient message survived the restart")
+	}
+
+	expectNotFound := func(what string, err error) {
+		t.Helper()
+		var amqpErr *amqp.Error
+		if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.NotFound {
+			t.Fatalf("%s: err = %v, want NOT_FOUND", what, err)
+		}
+	}
+	_, _, err = ch.Get("dropped", true)
+	expectNotFound("transient queue", err)
+	_, ch = newTestChannel(t, b)
+	expectNotFound("transient exchange", ch.QueueBind("kept", "#", "transient", false, nil))
+}
+
+func TestMemoryBrokerPrefetch(t *testing.T) {
+	b := NewMemoryBroker()
+	_, ch := newTestChannel(t, b)
+	ch.QueueDeclare("q", false, false, false, false, nil)
+	if err := ch.Qos(2, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := ch.Consume("q", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"1", "2", "3"} {
+		ch.PublishWithContext(context.Background(), "", "q", false, false, amqp.Publishing{Body: []byte(body)})
+	}
+
+	first := receive(t, deliveries)
+	receive(t, deliveries)
+	expectNone(t, deliveries)
+
+	first.Ack(false)
+	if d := receive(t, deliveries); string(d.Body) != "3" {
+		t.Fatalf("got %q after ack, want 3", d.Body)
+	}
+}
+
+func TestMemoryBrokerNack(t *testing.T) {
+	b := NewMemoryBroker()
+	_, ch := newTestChannel(t, b)
+	ch.ExchangeDeclare("dlx", amqp.ExchangeFanout, true, false, false, false, nil)
+	ch.QueueDeclare("dlq", true, false, false, false, nil)
+	ch.QueueBind("dlq", "", "dlx", false, nil)
+	ch.QueueDeclare("q", true, false, false, false, amqp.Table{"x-dead-letter-exchange": "dlx"})
+	deliveries, err := ch.Consume("q", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.PublishWithContext(context.Background(), "", "q", false, false, amqp.Publishing{Body: []byte("m")})
+
+	d := receive(t, deliveries)
+	if d.Redelivered {
+		t.Fatal("first delivery is marked redelivered")
+	}
+	d.Nack(false, true)
+	d = receive(t, deliveries)
+	if !d.Redelivered {
+		t.Fatal("requeued delivery is not marked redelivered")
+	}
+	d.Nack(false, false)
+
+	dl, ok, err := ch.Get("dlq", true)
+	if err != nil || !ok {
+		t.Fatalf("dead letter not routed: %v, %v", ok, err)
+	}
+	if reason := deathReason(dl.Headers); reason != "rejected" {
+		t.Fatalf("x-death reason = %q, want rejected", reason)
+	}
+}
+
+func deathReason(headers amqp.Table) string {
+	deaths, _ := headers["x-death"].([]any)
+	if len(deaths) == 0 {
+		return ""
+	}
+	death, _ := deaths[0].(amqp.Table)
+	reason, _ := death["reason"].(string)
+	return reason
+}
+
+func TestMemoryBrokerRedeclare(t *testing.T) {
+	tests := []struct {
+		name     string
+		declared amqp.Table
+		again    amqp.Table
+		wantErr  bool
+	}{
+		{"same args", amqp.Table{"x-max-priority": int64(10)}, amqp.Table{"x-max-priority": 10}, false},
+		{"classic is the default type", nil, amqp.Table{"x-queue-type": "classic"}, false},
+		{"different type", nil, amqp.Table{"x-queue-type": "quorum"}, true},
+		{"added priority", nil, amqp.Table{"x-max-priority": int64(10)}, true},
+		{"removed dead letter exchange", amqp.Table{"x-dead-letter-exchange": "dlx"}, nil, true},
+		{"unrelated header", nil, amqp.Table{"x-custom": "ignored"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ch := newTestChannel(t, NewMemoryBroker())
+			if _, err := ch.QueueDeclare("q", true, false, false, false, tt.declared); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ch.QueueDeclare("q", true, false, false, false, tt.again)
+			var amqpErr *amqp.Error
+			if tt.wantErr && (!errors.As(err, &amqpErr) || amqpErr.Code != amqp.PreconditionFailed) {
+				t.Fatalf("err = %v, want PRECONDITION_FAILED", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerRoundTrip(t *testing.T) {
+	b := NewMemoryBroker()
+	server := b.Connect()
+	client := b.Connect()
+	if err := DeclareTopology(server, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := make(chan routing.GameLog, 1)
+	_, err := Subscribe(context.Background(), server, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", Durable, func(gl routing.GameLog) AckType {
+		logs <- gl
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan routing.PlayingState, 1)
+	_, err = Subscribe(context.Background(), client, routing.ExchangePerilDirect, routing.PauseKey+".alice", routing.PauseKey, Transient, func(ps routing.PlayingState) AckType {
+		states <- ps
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCh, _ := server.Channel()
+	clientCh, _ := client.Channel()
+	if err := PublishJSON(serverCh, routing.ExchangePerilDirect, routing.PauseKey, routing.PlayingState{IsPaused: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := routing.GameLog{CurrentTime: time.Now().UTC().Truncate(time.Second), Message: "alice won a war", Username: "alice"}
+	if err := PublishGob(clientCh, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", want); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ps := <-states:
+		if !ps.IsPaused {
+			t.Fatal("client received resume, want pause")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive the pause")
+	}
+	select {
+	case got := <-logs:
+		if !got.CurrentTime.Equal(want.CurrentTime) || got.Message != want.Message || got.Username != want.Username {
+			t.Fatalf("server received %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive the game log")
+	}
+}
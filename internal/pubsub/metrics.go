@@ -0,0 +1,77 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Only the first segment of a routing key is used as a label, such as
+// "army_moves" for "army_moves.alice": the full keys of reply-to queues,
+// scheduled delay queues and per-player messages would make the number of
+// series unbounded.
+var (
+	publishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "published_total",
+		Help:      "Messages published, by exchange, routing key prefix and result.",
+	}, []string{"exchange", "routing_key", "result"})
+
+	consumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "consumed_total",
+		Help:      "Deliveries handled, by queue, routing key prefix and how they were settled.",
+	}, []string{"queue", "routing_key", "ack"})
+
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "handler_duration_seconds",
+		Help:      "Time spent in subscription handlers, by queue.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"queue"})
+
+	decodeFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "decode_failures_total",
+		Help:      "Deliveries that could not be decoded, by queue.",
+	}, []string{"queue"})
+
+	duplicatesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "duplicates_total",
+		Help:      "Deliveries skipped by deduplication, by queue.",
+	}, []string{"queue"})
+)
+
+// routingKeyLabel is the part of key before the first ".".
+func routingKeyLabel(key string) string {
+	prefix, _, _ := strings.Cut(key, ".")
+	return prefix
+}
+
+func observePublish(exchange, key string, err error) {
+	result := "ok"
+	var returned *ReturnedError
+	switch {
+	case errors.As(err, &returned):
+		result = "returned"
+	case errors.Is(err, ErrNacked):
+		result = "nacked"
+	case err != nil:
+		result = "error"
+	}
+	publishedTotal.WithLabelValues(exchange, routingKeyLabel(key), result).Inc()
+}
+
+func observeHandled(queue, key string, ack AckType, took time.Duration) {
+	consumedTotal.WithLabelValues(queue, routingKeyLabel(key), strings.ReplaceAll(ack.String(), " ", "_")).Inc()
+	handlerDuration.WithLabelValues(queue).Observe(took.Seconds())
+}
@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestMetricsLabelRoutingKeyPrefix(t *testing.T) {
+	// The counters are global, so each run needs an exchange and queue of
+	// its own.
+	exchange := "metrics-" + NewMessageID()
+	queue := "metrics-" + NewMessageID()
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	handled := make(chan struct{}, 10)
+	_, err := Subscribe(context.Background(), conn, exchange, queue, "#", Transient, func(int) AckType {
+		handled <- struct{}{}
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.CollectAndCount(publishedTotal)
+	for i := 0; i < 5; i++ {
+		// Every player publishes under a key of their own.
+		if err := Publish(context.Background(), ch, exchange, "army_moves."+NewMessageID(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Publish(context.Background(), ch, exchange, "war.alice", 5); err != nil {
+		t.Fatal(err)
+	}
+	if added := testutil.CollectAndCount(publishedTotal) - before; added != 2 {
+		t.Fatalf("6 routing keys added %d series, want 2", added)
+	}
+	if n := testutil.ToFloat64(publishedTotal.WithLabelValues(exchange, "army_moves", "ok")); n != 5 {
+		t.Errorf("published_total{routing_key=army_moves} = %v, want 5", n)
+	}
+	if n := testutil.ToFloat64(publishedTotal.WithLabelValues(exchange, "war", "ok")); n != 1 {
+		t.Errorf("published_total{routing_key=war} = %v, want 1", n)
+	}
+
+	for i := 0; i < 6; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for deliveries")
+		}
+	}
+	// The handled counter is bumped just after the handler returns.
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(consumedTotal.WithLabelValues(queue, "war", "ack")) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := testutil.ToFloat64(consumedTotal.WithLabelValues(queue, "army_moves", "ack")); n != 5 {
+		t.Errorf("consumed_total{routing_key=army_moves} = %v, want 5", n)
+	}
+	if n := testutil.ToFloat64(consumedTotal.WithLabelValues(queue, "war", "ack")); n != 1 {
+		t.Errorf("consumed_total{routing_key=war} = %v, want 1", n)
+	}
+}
@@ -0,0 +1,153 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type Handler[T any] func(ctx context.Context, msg T, env Envelope) AckType
+
+// Middleware wraps a Handler. Middlewares run in the order they are given,
+// so the first one is the outermost.
+type Middleware[T any] func(next Handler[T]) Handler[T]
+
+var globalMiddlewares struct {
+	sync.RWMutex
+	list []Middleware[any]
+}
+
+// Use adds middlewares that wrap every subscription created afterwards,
+// outside of the ones passed with WithMiddleware.
+func Use(mw ...Middleware[any]) {
+	globalMiddlewares.Lock()
+	defer globalMiddlewares.Unlock()
+	globalMiddlewares.list = append(globalMiddlewares.list, mw...)
+}
+
+func chain[T any](h Handler[T], mw []Middleware[T]) Handler[T] {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+func buildHandler[T any](h Handler[T], local []any) (Handler[any], error) {
+	typed := make([]Middleware[T], 0, len(local))
+	for _, mw := range local {
+		m, ok := mw.(Middleware[T])
+		if !ok {
+			var zero T
+			return nil, fmt.Errorf("pubsub: middleware %T does not handle %T", mw, zero)
+		}
+		typed = append(typed, m)
+	}
+	h = chain(h, typed)
+
+	globalMiddlewares.RLock()
+	global := append([]Middleware[any](nil), globalMiddlewares.list...)
+	globalMiddlewares.RUnlock()
+	return chain(func(ctx context.Context, msg any, env Envelope) AckType {
+		return h(ctx, msg.(T), env)
+	}, global), nil
+}
+
+// Recover turns a panicking handler into a NackDiscard, so the message goes
+// to the dead-letter queue instead of crashing the process.
+func Recover[T any]() Middleware[T] {
+	return func(next Handler[T]) Handler[T] {
+		return func(ctx context.Context, msg T, env Envelope) (ack AckType) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger().Error("handler panicked", "queue", env.Queue, "message_id", env.MessageID, "panic", r, "stack", string(debug.Stack()))
+					ack = NackDiscard
+				}
+			}()
+			return next(ctx, msg, env)
+		}
+	}
+}
+
+// Timing reports how long each handler call took.
+func Timing[T any](report func(env Envelope, ack AckType, took time.Duration)) Middleware[T] {
+	return func(next Handler[T]) Handler[T] {
+		return func(ctx context.Context, msg T, env Envelope) AckType {
+			start := time.Now()
+			ack := next(ctx, msg, env)
+			report(env, ack, time.Since(start))
+			return ack
+		}
+	}
+}
+
+// Logging logs the outcome of every handled message to l, or to the
+// package logger if l is nil. Acks are logged at debug level, requeues and
+// retries at info and discards at warn.
+func Logging[T any](l *slog.Logger) Middleware[T] {
+	return Timing[T](func(env Envelope, ack AckType, took time.Duration) {
+		out := l
+		if out == nil {
+			out = logger()
+		}
+		level := slog.LevelInfo
+		switch ack {
+		case Ack:
+			level = slog.LevelDebug
+		case NackDiscard:
+			level = slog.LevelWarn
+		}
+		out.Log(context.Background(), level, "handled message",
+			"queue", env.Queue,
+			"routing_key", env.RoutingKey,
+			"message_id", env.MessageID,
+			"ack", ack.String(),
+			"took", took,
+		)
+	})
+}
+
+// Timeout cancels the handler's context after d and answers with onTimeout
+// if the handler has not returned by then. The handler keeps running in the
+// background, so it should give up once its context is done.
+func Timeout[T any](d time.Duration, onTimeout AckType) Middleware[T] {
+	return func(next Handler[T]) Handler[T] {
+		return func(ctx context.Context, msg T, env Envelope) AckType {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			result := make(chan AckType, 1)
+			panicked := make(chan any, 1)
+			go func() {
+				// Hand panics back so Recover further out still sees them.
+				defer func() {
+					if r := recover(); r != nil {
+						panicked <- r
+					}
+				}()
+				result <- next(ctx, msg, env)
+			}()
+			select {
+			case ack := <-result:
+				return ack
+			case r := <-panicked:
+				panic(r)
+			case <-ctx.Done():
+				logger().Warn("handler timed out", "queue", env.Queue, "message_id", env.MessageID, "timeout", d)
+				return onTimeout
+			}
+		}
+	}
+}
+
+// MetricsRecorder receives one observation per handled message.
+type MetricsRecorder interface {
+	ObserveHandled(queue string, ack AckType, took time.Duration)
+}
+
+func Metrics[T any](rec MetricsRecorder) Middleware[T] {
+	return Timing[T](func(env Envelope, ack AckType, took time.Duration) {
+		rec.ObserveHandled(env.Queue, ack, took)
+	})
+}
@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware[string] {
+		return func(next Handler[string]) Handler[string] {
+			return func(ctx context.Context, msg string, env Envelope) AckType {
+				calls = append(calls, name)
+				return next(ctx, msg, env)
+			}
+		}
+	}
+	h := chain(func(context.Context, string, Envelope) AckType {
+		calls = append(calls, "handler")
+		return Ack
+	}, []Middleware[string]{trace("outer"), trace("inner")})
+	h(context.Background(), "hi", Envelope{})
+
+	if want := []string{"outer", "inner", "handler"}; !slices.Equal(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRecoverAndTimeout(t *testing.T) {
+	panics := func(context.Context, string, Envelope) AckType { panic("boom") }
+	if ack := Recover[string]()(panics)(context.Background(), "hi", Envelope{}); ack != NackDiscard {
+		t.Errorf("Recover answered %s, want NackDiscard", ack)
+	}
+
+	// Timeout hands the panic back so Recover outside it still sees it.
+	h := chain(panics, []Middleware[string]{Recover[string](), Timeout[string](time.Second, NackRequeue)})
+	if ack := h(context.Background(), "hi", Envelope{}); ack != NackDiscard {
+		t.Errorf("Recover around Timeout answered %s, want NackDiscard", ack)
+	}
+
+	slow := func(ctx context.Context, _ string, _ Envelope) AckType {
+		<-ctx.Done()
+		return Ack
+	}
+	if ack := Timeout[string](10*time.Millisecond, NackRequeue)(slow)(context.Background(), "hi", Envelope{}); ack != NackRequeue {
+		t.Errorf("Timeout answered %s, want NackRequeue", ack)
+	}
+}
+
+func TestWithMiddlewareType(t *testing.T) {
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeTopic, true, false, false, false, nil)
+
+	_, err := Subscribe(context.Background(), conn, "x", "q", "#", Durable, func(string) AckType { return Ack }, WithMiddleware(Recover[int]()))
+	if err == nil {
+		t.Fatal("subscribed with middleware for the wrong type")
+	}
+
+	got := make(chan AckType, 1)
+	_, err = Subscribe(context.Background(), conn, "x", "q", "#", Durable, func(string) AckType { panic("boom") },
+		WithMiddleware(Timing[string](func(_ Envelope, ack AckType, _ time.Duration) { got <- ack }), Recover[string]()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	PublishJSON(ch, "x", "k", "hi")
+	select {
+	case ack := <-got:
+		if ack != NackDiscard {
+			t.Fatalf("Timing saw %s, want NackDiscard", ack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the handler")
+	}
+}
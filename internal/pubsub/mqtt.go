@@ -0,0 +1,751 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MQTT support speaks MQTT 3.1.1, or MQTT 5 when the URL asks for it with
+// ?version=5. Routing keys become topics by swapping "." for "/", so army_moves.bob is
+// published on army_moves/bob, and exchanges other than the Peril direct
+// and topic exchanges add their name as the first level. A non-exclusive
+// queue becomes a shared subscription ($share/<queue>/...) whose consumers
+// compete for messages like on a queue; any other queue is a plain
+// subscription. Shared subscriptions are a broker extension in 3.1.1:
+// Mosquitto and EMQX support them, RabbitMQ refuses them, so over RabbitMQ
+// only exclusive queues can be consumed.
+//
+// MQTT 3.1.1 messages carry no properties, so content type, message IDs and
+// trace context are lost and subscribers fall back to their default content
+// type. MQTT 5 keeps them: content type, correlation ID, reply-to and
+// expiration have MQTT properties of their own, and the rest travel as user
+// properties. Requeued and retried messages travel on a topic only that
+// queue's consumers subscribe to,
+// peril/queue/<queue>/<attempt>/<due>/<original topic>, where due is the
+// Unix time in milliseconds before which a retry must not be handled. The
+// consumer holds such a message unacknowledged until then, so the broker
+// still has it if the process dies. MQTT 5 acknowledges messages in the
+// order they arrived, so a held retry also holds back the acknowledgements
+// of the messages after it. Delay queues that dead-letter to an exchange,
+// as PublishAt uses, are not supported.
+
+const (
+	// headerRetain marks a message the broker should keep as the last known
+	// value of its topic. Only MQTT acts on it.
+	headerRetain = "x-retain"
+	// headerMQTTTopic holds the topic a message was originally published
+	// on, since requeues and retries move it to the queue's own topic.
+	headerMQTTTopic = "x-mqtt-topic"
+
+	mqttQueueTopicPrefix = "peril/queue/"
+	mqttTimeout          = 10 * time.Second
+	// mqttSubackFailure is the lowest SUBACK code for a refused filter.
+	mqttSubackFailure = 0x80
+	// mqttSessionExpiry is how long an MQTT 5 broker keeps a persistent
+	// session after its client disconnects. MQTT 3.1.1 keeps it for good.
+	mqttSessionExpiry = 24 * time.Hour
+)
+
+// errMQTTRefused is returned by mqttClient.subscribe for a filter the broker
+// refused.
+var errMQTTRefused = errors.New("pubsub: MQTT broker refused the subscription")
+
+// mqttClient is what the broker needs from an MQTT client, so that MQTT
+// 3.1.1 and MQTT 5 share everything else.
+type mqttClient interface {
+	// publish sends m at QoS 1 and waits for the broker's PUBACK.
+	publish(ctx context.Context, m mqttMessage) error
+	// subscribe calls handle for every message that arrives on filter.
+	subscribe(ctx context.Context, filter string, handle func(mqttMessage)) error
+	unsubscribe(ctx context.Context, filters ...string) error
+	disconnect()
+}
+
+// mqttMessage is a message as it travels over MQTT. Only the body survives
+// MQTT 3.1.1; MQTT 5 keeps the other properties too.
+type mqttMessage struct {
+	amqp.Publishing
+	topic     string
+	retain    bool
+	duplicate bool
+	// ack acknowledges a message that arrived. Calling it again is a no-op.
+	ack func()
+}
+
+// mqttOptions are what a client needs to connect.
+type mqttOptions struct {
+	addr     string
+	secure   bool
+	tls      *tls.Config
+	user     *url.Userinfo
+	clientID string
+	clean    bool
+	// lost is called when the connection fails.
+	lost func(error)
+}
+
+// DialMQTT is like Dial for mqtt:// and mqtts:// URLs. It speaks MQTT 3.1.1
+// unless the URL ends in ?version=5. The URL's user info is used as
+// username and password, and the connection name, if any, as the client ID
+// of a persistent session, so the broker keeps messages for its
+// subscriptions while it is disconnected. Without a name the client gets a
+// random ID and a clean session.
+func DialMQTT(url string, cfg *tls.Config, opts ...ConnectionOption) (*Connection, error) {
+	c := newConnection(opts)
+	if c.externalAuth() {
+		return nil, fmt.Errorf("pubsub: MQTT does not support EXTERNAL authentication: %w", errors.ErrUnsupported)
+	}
+	clientID := c.connectionName()
+	c.dial = func() (Broker, error) {
+		return dialMQTT(url, cfg, clientID)
+	}
+	return c.start()
+}
+
+// mqttTopic translates an exchange and routing key, or binding pattern, to
+// an MQTT topic or topic filter.
+func mqttTopic(exchange, key string) string {
+	levels := strings.Split(key, ".")
+	for i, level := range levels {
+		if level == "*" {
+			levels[i] = "+"
+		}
+	}
+	topic := strings.Join(levels, "/")
+	if exchange == routing.ExchangePerilDirect || exchange == routing.ExchangePerilTopic {
+		return topic
+	}
+	return exchange + "/" + topic
+}
+
+// mqttRoutingKey reverses mqttTopic for a topic a message arrived on.
+func mqttRoutingKey(exchange, topic string) string {
+	if exchange != routing.ExchangePerilDirect && exchange != routing.ExchangePerilTopic {
+		topic = strings.TrimPrefix(topic, exchange+"/")
+	}
+	return strings.ReplaceAll(topic, "/", ".")
+}
+
+// mqttQueueTopic is the topic a message for queue travels on when it is
+// requeued or retried. A zero due time means it can be handled at once.
+func mqttQueueTopic(queue string, attempt int64, due time.Time, topic string) string {
+	var ms int64
+	if !due.IsZero() {
+		// Round up, so the message is never handled early.
+		ms = due.Add(time.Millisecond - 1).UnixMilli()
+	}
+	return mqttQueueTopicPrefix + queue + "/" + strconv.FormatInt(attempt, 10) + "/" + strconv.FormatInt(ms, 10) + "/" + topic
+}
+
+// parseMQTTQueueTopic reverses mqttQueueTopic for the part of the topic
+// after the queue's prefix.
+func parseMQTTQueueTopic(rest string) (attempt int64, due time.Time, topic string) {
+	n, rest, _ := strings.Cut(rest, "/")
+	ms, topic, _ := strings.Cut(rest, "/")
+	attempt, _ = strconv.ParseInt(n, 10, 64)
+	if v, _ := strconv.ParseInt(ms, 10, 64); v > 0 {
+		due = time.UnixMilli(v)
+	}
+	return attempt, due, topic
+}
+
+type mqttBroker struct {
+	client mqttClient
+
+	mu     sync.Mutex
+	closed bool
+	nextID uint64
+	// queues are shared by all channels, like a broker's queues, so a
+	// queue declared on one channel can be published to from another.
+	queues   map[string]*mqttQueue
+	channels map[*mqttChannel]struct{}
+	notify   []chan *amqp.Error
+	done     chan struct{}
+}
+
+func dialMQTT(rawURL string, cfg *tls.Config, clientID string) (*mqttBroker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	o := mqttOptions{tls: cfg, user: u.User, clientID: clientID, clean: clientID == ""}
+	switch u.Scheme {
+	case "mqtt":
+		o.addr = withDefaultPort(u, "1883")
+	case "mqtts":
+		o.addr = withDefaultPort(u, "8883")
+		o.secure = true
+	default:
+		return nil, fmt.Errorf("pubsub: unsupported MQTT scheme %q", u.Scheme)
+	}
+	if o.clean {
+		o.clientID = "peril-" + strings.ReplaceAll(NewMessageID(), "-", "")[:16]
+	}
+
+	b := &mqttBroker{
+		queues:   map[string]*mqttQueue{},
+		channels: map[*mqttChannel]struct{}{},
+		done:     make(chan struct{}),
+	}
+	o.lost = func(err error) {
+		b.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: err.Error(), Recover: true})
+	}
+	switch v := u.Query().Get("version"); v {
+	case "", "3.1.1":
+		b.client, err = dialMQTT3(o)
+	case "5":
+		b.client, err = dialMQTT5(o)
+	default:
+		return nil, fmt.Errorf("pubsub: unsupported MQTT version %q", v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func withDefaultPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (b *mqttBroker) Channel() (Channel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &mqttChannel{
+		b:         b,
+		consumers: map[string]*mqttConsumer{},
+		pending:   map[uint64]mqttPending{},
+	}
+	b.channels[ch] = struct{}{}
+	return ch, nil
+}
+
+func (b *mqttBroker) Close() error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return amqp.ErrClosed
+	}
+	b.client.disconnect()
+	b.shutdown(nil)
+	return nil
+}
+
+func (b *mqttBroker) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(receiver)
+		return receiver
+	}
+	b.notify = append(b.notify, receiver)
+	return receiver
+}
+
+func (b *mqttBroker) shutdown(err *amqp.Error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.done)
+	channels := b.channels
+	b.channels = map[*mqttChannel]struct{}{}
+	notify := b.notify
+	b.notify = nil
+	b.mu.Unlock()
+
+	for ch := range channels {
+		ch.shutdown()
+	}
+	for _, receiver := range notify {
+		if err != nil {
+			select {
+			case receiver <- err:
+			default:
+			}
+		}
+		close(receiver)
+	}
+}
+
+func (b *mqttBroker) genID(prefix string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	return fmt.Sprintf("%s-%d", prefix, b.nextID)
+}
+
+func (b *mqttBroker) publish(ctx context.Context, m mqttMessage) error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return amqp.ErrClosed
+	}
+	return b.client.publish(ctx, m)
+}
+
+type mqttQueue struct {
+	shared   bool
+	args     amqp.Table
+	bindings [][2]string
+}
+
+type mqttConsumer struct {
+	*deliveryBuffer
+	tag     string
+	queue   string
+	autoAck bool
+	filters []string
+}
+
+type mqttPending struct {
+	msg     mqttMessage
+	queue   string
+	attempt int64
+	due     time.Time
+	topic   string
+}
+
+type mqttChannel struct {
+	b *mqttBroker
+
+	mu        sync.Mutex
+	closed    bool
+	consumers map[string]*mqttConsumer
+	nextTag   uint64
+	pending   map[uint64]mqttPending
+	confirm   bool
+	seq       uint64
+	confirms  []chan amqp.Confirmation
+	returns   []chan amqp.Return
+}
+
+func (ch *mqttChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	retain, _ := msg.Headers[headerRetain].(bool)
+	m := mqttMessage{Publishing: msg, topic: mqttTopic(exchange, key), retain: retain}
+
+	ch.mu.Lock()
+	closed := ch.closed
+	ch.mu.Unlock()
+	if closed {
+		return amqp.ErrClosed
+	}
+	q := ch.b.queue(key)
+
+	if exchange == "" {
+		// The default exchange routes to a queue by name, which here means
+		// the queue's own topic.
+		attempt, _ := tableInt(msg.Headers, retryAttemptHeader)
+		original, _ := msg.Headers[headerMQTTTopic].(string)
+		ttl, hasTTL := tableInt(q.argsOrNil(), "x-message-ttl")
+		target, isRetry := q.argsOrNil()["x-dead-letter-routing-key"].(string)
+		switch dlx, _ := q.argsOrNil()["x-dead-letter-exchange"].(string); {
+		case hasTTL && isRetry && dlx != "":
+			return fmt.Errorf("pubsub: MQTT does not support delayed publishing to an exchange: %w", errors.ErrUnsupported)
+		case hasTTL && isRetry:
+			// A retry queue: the message goes straight back to the queue it
+			// dead-letters into, marked with when it is due.
+			due := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+			m.topic, m.retain = mqttQueueTopic(target, attempt, due, original), false
+		default:
+			m.topic = mqttQueueTopic(key, attempt, time.Time{}, original)
+		}
+	}
+	if err := ch.b.publish(ctx, m); err != nil {
+		return err
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.confirm {
+		ch.seq++
+		for _, l := range ch.confirms {
+			select {
+			case l <- amqp.Confirmation{DeliveryTag: ch.seq, Ack: true}:
+			case <-ch.b.done:
+			}
+		}
+	}
+	return nil
+}
+
+// queue returns a copy of what is known about the named queue, or nil.
+func (b *mqttBroker) queue(name string) *mqttQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queues[name]
+	if q == nil {
+		return nil
+	}
+	c := *q
+	return &c
+}
+
+func (q *mqttQueue) argsOrNil() amqp.Table {
+	if q == nil {
+		return nil
+	}
+	return q.args
+}
+
+func (ch *mqttChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if name == "" {
+		name = ch.b.genID("mqtt.gen")
+	}
+	ch.mu.Lock()
+	closed := ch.closed
+	ch.mu.Unlock()
+	if closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	ch.b.mu.Lock()
+	defer ch.b.mu.Unlock()
+	q := ch.b.queues[name]
+	if q == nil {
+		q = &mqttQueue{}
+		ch.b.queues[name] = q
+	}
+	q.shared = !exclusive
+	q.args = args
+	return amqp.Queue{Name: name}, nil
+}
+
+// QueueBind only records the binding. It becomes a subscription once the
+// queue is consumed from.
+func (ch *mqttChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	ch.mu.Lock()
+	closed := ch.closed
+	ch.mu.Unlock()
+	if closed {
+		return amqp.ErrClosed
+	}
+	ch.b.mu.Lock()
+	defer ch.b.mu.Unlock()
+	q := ch.b.queues[name]
+	if q == nil {
+		q = &mqttQueue{shared: true}
+		ch.b.queues[name] = q
+	}
+	if binding := [2]string{exchange, key}; exchange != "" && !slices.Contains(q.bindings, binding) {
+		q.bindings = append(slices.Clip(q.bindings), binding)
+	}
+	return nil
+}
+
+// Qos is a no-op: MQTT has no per-consumer flow control.
+func (ch *mqttChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (ch *mqttChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if queue == directReplyTo {
+		return nil, fmt.Errorf("pubsub: MQTT does not support direct reply-to: %w", errors.ErrUnsupported)
+	}
+	if _, ok := args[headerStreamOffset]; ok {
+		return nil, fmt.Errorf("pubsub: MQTT does not support streams: %w", errors.ErrUnsupported)
+	}
+	if consumer == "" {
+		consumer = ch.b.genID("ctag")
+	}
+
+	ch.mu.Lock()
+	closed := ch.closed
+	ch.mu.Unlock()
+	if closed {
+		return nil, amqp.ErrClosed
+	}
+	q := ch.b.queue(queue)
+	if q == nil {
+		q = &mqttQueue{shared: true}
+	}
+	// exchanges remembers which binding a filter belongs to, to recover
+	// the routing key of what arrives on it.
+	exchanges := map[string]string{mqttQueueTopicPrefix + queue + "/#": ""}
+	for _, binding := range q.bindings {
+		exchanges[mqttTopic(binding[0], binding[1])] = binding[0]
+	}
+	shared := q.shared
+
+	c := &mqttConsumer{deliveryBuffer: newDeliveryBuffer(), tag: consumer, queue: queue, autoAck: autoAck}
+	ctx, cancel := context.WithTimeout(context.Background(), mqttTimeout)
+	defer cancel()
+	for filter, exchange := range exchanges {
+		if shared {
+			filter = "$share/" + queue + "/" + filter
+		}
+		exchange := exchange
+		err := ch.b.client.subscribe(ctx, filter, func(m mqttMessage) {
+			ch.deliver(c, exchange, m)
+		})
+		if errors.Is(err, errMQTTRefused) {
+			err = fmt.Errorf("pubsub: MQTT broker refused subscription to %q", filter)
+			if shared {
+				err = fmt.Errorf("pubsub: MQTT broker refused shared subscription %q; queue %s needs a broker that supports them: %w", filter, queue, errors.ErrUnsupported)
+			}
+		}
+		if err != nil {
+			ch.unsubscribe(c)
+			return nil, err
+		}
+		c.filters = append(c.filters, filter)
+	}
+
+	ch.mu.Lock()
+	ch.consumers[consumer] = c
+	ch.mu.Unlock()
+	return c.out, nil
+}
+
+func (ch *mqttChannel) unsubscribe(c *mqttConsumer) {
+	if len(c.filters) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), mqttTimeout)
+		defer cancel()
+		ch.b.client.unsubscribe(ctx, c.filters...)
+	}
+	c.close()
+}
+
+func (ch *mqttChannel) deliver(c *mqttConsumer, exchange string, m mqttMessage) {
+	topic := m.topic
+	d := amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         amqp.Table{},
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		Priority:        m.Priority,
+		CorrelationId:   m.CorrelationId,
+		ReplyTo:         m.ReplyTo,
+		Expiration:      m.Expiration,
+		MessageId:       m.MessageId,
+		Timestamp:       m.Timestamp,
+		Type:            m.Type,
+		UserId:          m.UserId,
+		AppId:           m.AppId,
+		ConsumerTag:     c.tag,
+		Exchange:        exchange,
+		Redelivered:     m.duplicate,
+		Body:            m.Body,
+	}
+	for k, v := range m.Headers {
+		d.Headers[k] = v
+	}
+	var attempt int64
+	var due time.Time
+	if rest, ok := strings.CutPrefix(topic, mqttQueueTopicPrefix+c.queue+"/"); ok {
+		attempt, due, topic = parseMQTTQueueTopic(rest)
+		if attempt > 0 {
+			d.Headers[retryAttemptHeader] = attempt
+		}
+		d.Redelivered = true
+	}
+	d.RoutingKey = mqttRoutingKey(exchange, topic)
+	d.Headers[headerMQTTTopic] = topic
+
+	if c.autoAck {
+		m.ack()
+	} else {
+		ch.mu.Lock()
+		ch.nextTag++
+		d.DeliveryTag = ch.nextTag
+		ch.pending[d.DeliveryTag] = mqttPending{msg: m, queue: c.queue, attempt: attempt, due: due, topic: topic}
+		ch.mu.Unlock()
+	}
+	if wait := time.Until(due); wait > 0 && !c.autoAck {
+		// Not acknowledged yet, so a crash before then leaves the broker to
+		// redeliver it, due time and all.
+		time.AfterFunc(wait, func() { c.push(d) })
+		return
+	}
+	c.push(d)
+}
+
+func (ch *mqttChannel) settle(tag uint64, multiple bool) ([]mqttPending, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	var settled []mqttPending
+	for t, p := range ch.pending {
+		if t == tag || multiple && t < tag {
+			settled = append(settled, p)
+			delete(ch.pending, t)
+		}
+	}
+	if len(settled) == 0 {
+		return nil, fmt.Errorf("pubsub: unknown delivery tag %d", tag)
+	}
+	return settled, nil
+}
+
+func (ch *mqttChannel) Ack(tag uint64, multiple bool) error {
+	settled, err := ch.settle(tag, multiple)
+	for _, p := range settled {
+		p.msg.ack()
+	}
+	return err
+}
+
+// Nack acknowledges the message as far as MQTT is concerned. To requeue it,
+// a copy is first published to the queue's own topic.
+func (ch *mqttChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	settled, err := ch.settle(tag, multiple)
+	for _, p := range settled {
+		if !requeue {
+			p.msg.ack()
+			continue
+		}
+		if err := ch.requeue(p, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// requeue publishes a copy of p to its queue's own topic, to be handled no
+// sooner than due, and then acknowledges p.
+func (ch *mqttChannel) requeue(p mqttPending, due time.Time) error {
+	m := p.msg
+	m.topic, m.retain = mqttQueueTopic(p.queue, p.attempt, due, p.topic), false
+	if err := ch.b.publish(context.Background(), m); err != nil {
+		// Without a PUBACK the broker redelivers after a reconnect.
+		return err
+	}
+	p.msg.ack()
+	return nil
+}
+
+func (ch *mqttChannel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+func (ch *mqttChannel) Cancel(consumer string, noWait bool) error {
+	ch.mu.Lock()
+	c := ch.consumers[consumer]
+	delete(ch.consumers, consumer)
+	ch.mu.Unlock()
+	if c != nil {
+		ch.unsubscribe(c)
+	}
+	return nil
+}
+
+func (ch *mqttChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	return amqp.Delivery{}, false, fmt.Errorf("pubsub: MQTT does not support basic.get: %w", errors.ErrUnsupported)
+}
+
+func (ch *mqttChannel) QueuePurge(name string, noWait bool) (int, error) {
+	return 0, fmt.Errorf("pubsub: MQTT does not support queue.purge: %w", errors.ErrUnsupported)
+}
+
+func (ch *mqttChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	return 0, fmt.Errorf("pubsub: MQTT does not support queue.delete: %w", errors.ErrUnsupported)
+}
+
+// ExchangeDeclare is a no-op: MQTT has no exchanges, only topics.
+func (ch *mqttChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+// Confirm is satisfied by publishing at QoS 1: every publish already waits
+// for the broker's PUBACK.
+func (ch *mqttChannel) Confirm(noWait bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirm = true
+	return nil
+}
+
+func (ch *mqttChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(confirm)
+		return confirm
+	}
+	ch.confirms = append(ch.confirms, confirm)
+	return confirm
+}
+
+// NotifyReturn never delivers anything, since MQTT has no mandatory flag.
+func (ch *mqttChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(c)
+		return c
+	}
+	ch.returns = append(ch.returns, c)
+	return c
+}
+
+func (ch *mqttChannel) Close() error {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	consumers := ch.consumers
+	ch.consumers = map[string]*mqttConsumer{}
+	pending := ch.pending
+	ch.pending = map[uint64]mqttPending{}
+	ch.mu.Unlock()
+
+	for _, c := range consumers {
+		ch.unsubscribe(c)
+	}
+	// Like AMQP, closing the channel requeues what it left unacknowledged.
+	// MQTT 5 could not acknowledge anything after it otherwise.
+	for _, p := range pending {
+		ch.requeue(p, p.due)
+	}
+	ch.b.mu.Lock()
+	delete(ch.b.channels, ch)
+	ch.b.mu.Unlock()
+	ch.shutdown()
+	return nil
+}
+
+func (ch *mqttChannel) shutdown() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	for _, c := range ch.consumers {
+		c.close()
+	}
+	for _, c := range ch.confirms {
+		close(c)
+	}
+	for _, c := range ch.returns {
+		close(c)
+	}
+	ch.confirms, ch.returns = nil, nil
+}
@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"context"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// mqtt3Client speaks MQTT 3.1.1, which carries nothing but a message's body.
+type mqtt3Client struct {
+	c mqtt.Client
+}
+
+func dialMQTT3(o mqttOptions) (*mqtt3Client, error) {
+	server := "tcp://" + o.addr
+	if o.secure {
+		server = "ssl://" + o.addr
+	}
+	// The managed Connection reconnects and resubscribes, so paho must not.
+	opts := mqtt.NewClientOptions().
+		AddBroker(server).
+		SetClientID(o.clientID).
+		SetCleanSession(o.clean).
+		SetAutoReconnect(false).
+		SetConnectRetry(false).
+		SetOrderMatters(false).
+		SetAutoAckDisabled(true).
+		SetKeepAlive(mqttTimeout).
+		SetConnectTimeout(mqttTimeout).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			o.lost(err)
+		})
+	if o.user != nil {
+		opts.SetUsername(o.user.Username())
+		password, _ := o.user.Password()
+		opts.SetPassword(password)
+	}
+	if o.tls != nil {
+		opts.SetTLSConfig(o.tls)
+	}
+
+	c := &mqtt3Client{c: mqtt.NewClient(opts)}
+	ctx, cancel := context.WithTimeout(context.Background(), mqttTimeout)
+	defer cancel()
+	if err := mqttWait(ctx, c.c.Connect()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func mqttWait(ctx context.Context, t mqtt.Token) error {
+	select {
+	case <-t.Done():
+		return t.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *mqtt3Client) publish(ctx context.Context, m mqttMessage) error {
+	return mqttWait(ctx, c.c.Publish(m.topic, 1, m.retain, m.Body))
+}
+
+func (c *mqtt3Client) subscribe(ctx context.Context, filter string, handle func(mqttMessage)) error {
+	t := c.c.Subscribe(filter, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		handle(mqttMessage{
+			Publishing: amqp.Publishing{Body: msg.Payload()},
+			topic:      msg.Topic(),
+			retain:     msg.Retained(),
+			duplicate:  msg.Duplicate(),
+			ack:        msg.Ack,
+		})
+	})
+	if err := mqttWait(ctx, t); err != nil {
+		return err
+	}
+	// paho keys the result by filter without any $share prefix, so all
+	// codes are checked.
+	for _, code := range t.(*mqtt.SubscribeToken).Result() {
+		if code >= mqttSubackFailure {
+			return errMQTTRefused
+		}
+	}
+	return nil
+}
+
+func (c *mqtt3Client) unsubscribe(ctx context.Context, filters ...string) error {
+	return mqttWait(ctx, c.c.Unsubscribe(filters...))
+}
+
+func (c *mqtt3Client) disconnect() {
+	c.c.Disconnect(uint(mqttTimeout.Milliseconds()))
+}
@@ -0,0 +1,267 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// mqtt5Properties are the user properties that carry an AMQP property MQTT 5
+// has no property of its own for. Other user properties are headers.
+var mqtt5Properties = map[string]bool{
+	"content-encoding": true,
+	"priority":         true,
+	"amqp-message-id":  true,
+	"timestamp":        true,
+	"type":             true,
+	"user-id":          true,
+	"app-id":           true,
+}
+
+// mqtt5Client speaks MQTT 5, which keeps a message's properties.
+type mqtt5Client struct {
+	c       *paho.Client
+	closing atomic.Bool
+
+	mu sync.Mutex
+	// handlers are keyed by filter. paho hands every message to one
+	// callback, so they are matched here the way MQTT 3.1.1's client does.
+	handlers map[string]func(mqttMessage)
+}
+
+func dialMQTT5(o mqttOptions) (*mqtt5Client, error) {
+	d := &net.Dialer{Timeout: mqttTimeout}
+	var conn net.Conn
+	var err error
+	if o.secure {
+		conn, err = tls.DialWithDialer(d, "tcp", o.addr, o.tls)
+	} else {
+		conn, err = d.Dial("tcp", o.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mqtt5Client{handlers: map[string]func(mqttMessage){}}
+	lost := func(err error) {
+		if !c.closing.Load() {
+			o.lost(err)
+		}
+	}
+	c.c = paho.NewClient(paho.ClientConfig{
+		ClientID:                   o.clientID,
+		Conn:                       conn,
+		EnableManualAcknowledgment: true,
+		OnPublishReceived:          []func(paho.PublishReceived) (bool, error){c.received},
+		OnClientError:              lost,
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			lost(fmt.Errorf("pubsub: MQTT broker disconnected with reason code %#x", d.ReasonCode))
+		},
+		PacketTimeout: mqttTimeout,
+	})
+	cp := &paho.Connect{
+		ClientID:   o.clientID,
+		CleanStart: o.clean,
+		KeepAlive:  uint16(mqttTimeout / time.Second),
+	}
+	if !o.clean {
+		// Without an expiry an MQTT 5 session ends with its connection.
+		expiry := uint32(mqttSessionExpiry / time.Second)
+		cp.Properties = &paho.ConnectProperties{SessionExpiryInterval: &expiry}
+	}
+	if o.user != nil {
+		cp.Username, cp.UsernameFlag = o.user.Username(), true
+		if password, ok := o.user.Password(); ok {
+			cp.Password, cp.PasswordFlag = []byte(password), true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mqttTimeout)
+	defer cancel()
+	if _, err := c.c.Connect(ctx, cp); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *mqtt5Client) publish(ctx context.Context, m mqttMessage) error {
+	_, err := c.c.Publish(ctx, &paho.Publish{
+		Topic:      m.topic,
+		QoS:        1,
+		Retain:     m.retain,
+		Payload:    m.Body,
+		Properties: mqtt5PublishProperties(m.Publishing),
+	})
+	return err
+}
+
+func (c *mqtt5Client) subscribe(ctx context.Context, filter string, handle func(mqttMessage)) error {
+	// Retained messages can arrive before the SUBACK.
+	c.mu.Lock()
+	c.handlers[filter] = handle
+	c.mu.Unlock()
+	sa, err := c.c.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: filter, QoS: 1}},
+	})
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	delete(c.handlers, filter)
+	c.mu.Unlock()
+	// paho refuses up front what the CONNACK said the broker does not
+	// support, such as shared subscriptions.
+	if errors.Is(err, paho.ErrInvalidArguments) || sa != nil && len(sa.Reasons) > 0 && sa.Reasons[0] >= mqttSubackFailure {
+		return errMQTTRefused
+	}
+	return err
+}
+
+func (c *mqtt5Client) unsubscribe(ctx context.Context, filters ...string) error {
+	c.mu.Lock()
+	for _, filter := range filters {
+		delete(c.handlers, filter)
+	}
+	c.mu.Unlock()
+	_, err := c.c.Unsubscribe(ctx, &paho.Unsubscribe{Topics: filters})
+	return err
+}
+
+func (c *mqtt5Client) disconnect() {
+	c.closing.Store(true)
+	c.c.Disconnect(&paho.Disconnect{ReasonCode: 0})
+}
+
+func (c *mqtt5Client) received(pr paho.PublishReceived) (bool, error) {
+	p := pr.Packet
+	var once sync.Once
+	m := mqttMessage{
+		Publishing: mqtt5Publishing(p),
+		topic:      p.Topic,
+		retain:     p.Retain,
+		duplicate:  p.Duplicate(),
+		ack:        func() { once.Do(func() { c.c.Ack(p) }) },
+	}
+	var handlers []func(mqttMessage)
+	c.mu.Lock()
+	for filter, handle := range c.handlers {
+		if mqttMatch(filter, p.Topic) {
+			handlers = append(handlers, handle)
+		}
+	}
+	c.mu.Unlock()
+	if len(handlers) == 0 {
+		// Acknowledgements go out in order, so one never sent would hold
+		// back all the others.
+		m.ack()
+	}
+	for _, handle := range handlers {
+		handle(m)
+	}
+	return true, nil
+}
+
+// mqttMatch reports whether topic matches filter, which may be a shared
+// subscription's.
+func mqttMatch(filter, topic string) bool {
+	if rest, ok := strings.CutPrefix(filter, "$share/"); ok {
+		_, filter, _ = strings.Cut(rest, "/")
+	}
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func mqtt5PublishProperties(msg amqp.Publishing) *paho.PublishProperties {
+	p := &paho.PublishProperties{
+		ContentType:   msg.ContentType,
+		ResponseTopic: msg.ReplyTo,
+	}
+	if msg.CorrelationId != "" {
+		p.CorrelationData = []byte(msg.CorrelationId)
+	}
+	if ms, err := strconv.ParseInt(msg.Expiration, 10, 64); err == nil {
+		// MQTT counts in seconds; round up so the message is never dropped
+		// early.
+		expiry := uint32((ms + 999) / 1000)
+		p.MessageExpiry = &expiry
+	}
+
+	for k, v := range msg.Headers {
+		switch v.(type) {
+		case amqp.Table, []any, []byte:
+			continue
+		}
+		// The topic already says where the message goes and how often it
+		// has been tried.
+		if k == headerRetain || k == headerMQTTTopic || k == retryAttemptHeader || mqtt5Properties[k] {
+			continue
+		}
+		p.User.Add(k, fmt.Sprint(v))
+	}
+	add := func(k, v string) {
+		if v != "" {
+			p.User.Add(k, v)
+		}
+	}
+	add("content-encoding", msg.ContentEncoding)
+	add("amqp-message-id", msg.MessageId)
+	add("type", msg.Type)
+	add("user-id", msg.UserId)
+	add("app-id", msg.AppId)
+	if msg.Priority != 0 {
+		p.User.Add("priority", strconv.Itoa(int(msg.Priority)))
+	}
+	if !msg.Timestamp.IsZero() {
+		p.User.Add("timestamp", strconv.FormatInt(msg.Timestamp.Unix(), 10))
+	}
+	return p
+}
+
+func mqtt5Publishing(p *paho.Publish) amqp.Publishing {
+	msg := amqp.Publishing{Headers: amqp.Table{}, Body: p.Payload}
+	props := p.Properties
+	if props == nil {
+		return msg
+	}
+	msg.ContentType = props.ContentType
+	msg.CorrelationId = string(props.CorrelationData)
+	msg.ReplyTo = props.ResponseTopic
+	if props.MessageExpiry != nil {
+		msg.Expiration = strconv.FormatUint(uint64(*props.MessageExpiry)*1000, 10)
+	}
+	for _, u := range props.User {
+		if !mqtt5Properties[u.Key] {
+			msg.Headers[u.Key] = u.Value
+		}
+	}
+	msg.ContentEncoding = props.User.Get("content-encoding")
+	msg.MessageId = props.User.Get("amqp-message-id")
+	msg.Type = props.User.Get("type")
+	msg.UserId = props.User.Get("user-id")
+	msg.AppId = props.User.Get("app-id")
+	if priority, err := strconv.Atoi(props.User.Get("priority")); err == nil {
+		msg.Priority = uint8(priority)
+	}
+	if ts, err := strconv.ParseInt(props.User.Get("timestamp"), 10, 64); err == nil {
+		msg.Timestamp = time.Unix(ts, 0).UTC()
+	}
+	return msg
+}
@@ -0,0 +1,329 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// mqttVersions are the protocol versions every MQTT test runs against.
+var mqttVersions = []string{"3.1.1", "5"}
+
+// testMQTT is a hook on an in-process mochi-mqtt broker that lets every
+// client in, can refuse shared subscriptions the way RabbitMQ does, and
+// records connects and acknowledgements.
+type testMQTT struct {
+	mochi.HookBase
+	refuseShared bool
+
+	mu       sync.Mutex
+	connects []mqttConnect
+	acked    map[string]time.Time // topic -> when a client acknowledged it
+}
+
+type mqttConnect struct {
+	clientID string
+	clean    bool
+	version  byte
+	expiry   uint32
+}
+
+// newTestMQTT starts a broker and returns the URL to reach it with version.
+func newTestMQTT(t *testing.T, version string, refuseShared bool) (*testMQTT, string) {
+	t.Helper()
+	server := mochi.New(&mochi.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	h := &testMQTT{refuseShared: refuseShared, acked: map[string]time.Time{}}
+	if err := server.AddHook(h, nil); err != nil {
+		t.Fatal(err)
+	}
+	l := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	if err := server.AddListener(l); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return h, "mqtt://" + l.Address() + "?version=" + version
+}
+
+func (h *testMQTT) ID() string { return "peril-test" }
+
+func (h *testMQTT) Provides(b byte) bool {
+	return bytes.Contains([]byte{mochi.OnConnectAuthenticate, mochi.OnACLCheck, mochi.OnConnect, mochi.OnPacketRead}, []byte{b})
+}
+
+func (h *testMQTT) OnConnectAuthenticate(*mochi.Client, packets.Packet) bool { return true }
+
+func (h *testMQTT) OnACLCheck(_ *mochi.Client, filter string, write bool) bool {
+	return write || !h.refuseShared || !strings.HasPrefix(filter, "$share/")
+}
+
+func (h *testMQTT) OnConnect(_ *mochi.Client, pk packets.Packet) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connects = append(h.connects, mqttConnect{pk.Connect.ClientIdentifier, pk.Connect.Clean, pk.ProtocolVersion, pk.Properties.SessionExpiryInterval})
+	return nil
+}
+
+// OnPacketRead records when a client acknowledges a message the broker
+// sent it, which is still in flight until then.
+func (h *testMQTT) OnPacketRead(cl *mochi.Client, pk packets.Packet) (packets.Packet, error) {
+	if pk.FixedHeader.Type != packets.Puback {
+		return pk, nil
+	}
+	if sent, ok := cl.State.Inflight.Get(pk.PacketID); ok {
+		h.mu.Lock()
+		h.acked[sent.TopicName] = time.Now()
+		h.mu.Unlock()
+	}
+	return pk, nil
+}
+
+func dialTestMQTT(t *testing.T, url string, opts ...ConnectionOption) *Connection {
+	t.Helper()
+	conn, err := DialMQTT(url, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestMQTTBroker(t *testing.T) {
+	for _, version := range mqttVersions {
+		t.Run(version, func(t *testing.T) { testMQTTBroker(t, version) })
+	}
+}
+
+func testMQTTBroker(t *testing.T, version string) {
+	b, url := newTestMQTT(t, version, false)
+	server := dialTestMQTT(t, url)
+	if err := DeclareTopology(server, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+	pub := server.ConfirmingPublisher()
+	type state struct{ IsPaused bool }
+	if err := PublishJSON(pub, routing.ExchangePerilDirect, routing.PauseKey, state{true}, WithRetain()); err != nil {
+		t.Fatal(err)
+	}
+
+	type handled struct {
+		client string
+		n      int
+		at     time.Time
+	}
+	pauses := make(chan string, 4)
+	wars := make(chan handled, 8)
+	var mu sync.Mutex
+	seen := map[int]int{}
+	for _, name := range []string{"alice", "bob"} {
+		client := dialTestMQTT(t, url)
+		_, err := Subscribe(context.Background(), client, routing.ExchangePerilDirect, routing.PauseKey+"."+name, routing.PauseKey, Transient, func(s state) AckType {
+			if s.IsPaused {
+				pauses <- name
+			}
+			return Ack
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Subscribe(context.Background(), client, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix, routing.WarRecognitionsPrefix+".*", Durable, func(n int) AckType {
+			wars <- handled{name, n, time.Now()}
+			mu.Lock()
+			defer mu.Unlock()
+			seen[n]++
+			if n == 2 && seen[n] == 1 {
+				return RetryLater
+			}
+			return Ack
+		}, WithRetryPolicy(RetryPolicy{BaseDelay: 200 * time.Millisecond, MaxDelay: time.Second, MaxAttempts: 3}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both players get the retained pause state when they subscribe.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pauses:
+		case <-time.After(time.Second):
+			t.Fatal("a late subscriber missed the retained pause state")
+		}
+	}
+
+	// The shared war queue hands each message to one player only.
+	PublishJSON(pub, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix+".alice", 1)
+	PublishJSON(pub, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix+".bob", 2)
+	var got []handled
+	for len(got) < 3 {
+		select {
+		case h := <-wars:
+			got = append(got, h)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("handled %v, want war 1 once and war 2 twice", got)
+		}
+	}
+	select {
+	case h := <-wars:
+		t.Fatalf("war %d handled again by %s", h.n, h.client)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	var first, retried time.Time
+	for _, h := range got {
+		if h.n != 2 {
+			continue
+		}
+		if first.IsZero() {
+			first = h.at
+		} else {
+			retried = h.at
+		}
+	}
+	if retried.Sub(first) < 200*time.Millisecond {
+		t.Fatalf("war 2 retried after %v, want at least 200ms", retried.Sub(first))
+	}
+	// The retry is only acknowledged once it has been handled, so the
+	// broker would still have it had the player crashed while it waited.
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		var acked bool
+		for topic, at := range b.acked {
+			if !strings.HasPrefix(topic, mqttQueueTopicPrefix+routing.WarRecognitionsPrefix+"/1/") {
+				continue
+			}
+			if at.Before(retried) {
+				b.mu.Unlock()
+				t.Fatalf("retry on %s acknowledged %v before it was handled", topic, retried.Sub(at))
+			}
+			acked = true
+		}
+		b.mu.Unlock()
+		if acked {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the retry was never acknowledged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMQTTRefusedSharedSubscription(t *testing.T) {
+	for _, version := range mqttVersions {
+		t.Run(version, func(t *testing.T) {
+			_, url := newTestMQTT(t, version, true)
+			conn := dialTestMQTT(t, url)
+			handler := func(int) AckType { return Ack }
+
+			_, err := Subscribe(context.Background(), conn, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", Durable, handler)
+			if !errors.Is(err, errors.ErrUnsupported) {
+				t.Fatalf("err = %v, want errors.ErrUnsupported for a refused shared subscription", err)
+			}
+			_, err = Subscribe(context.Background(), conn, routing.ExchangePerilTopic, "army_moves.bob", "army_moves.*", Transient, handler)
+			if err != nil {
+				t.Fatalf("exclusive queue: %v", err)
+			}
+		})
+	}
+}
+
+func TestMQTTSession(t *testing.T) {
+	for _, version := range mqttVersions {
+		t.Run(version, func(t *testing.T) {
+			b, url := newTestMQTT(t, version, false)
+			dialTestMQTT(t, url)
+			dialTestMQTT(t, url, WithConnectionName("peril-client-bob"))
+
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if len(b.connects) != 2 {
+				t.Fatalf("%d connects, want 2", len(b.connects))
+			}
+			wantVersion := byte(4)
+			if version == "5" {
+				wantVersion = 5
+			}
+			for _, c := range b.connects {
+				if c.version != wantVersion {
+					t.Errorf("connected with protocol version %d, want %d", c.version, wantVersion)
+				}
+			}
+			if c := b.connects[0]; !strings.HasPrefix(c.clientID, "peril-") || !c.clean || c.expiry != 0 {
+				t.Errorf("unnamed connection: %+v, want a random ID and a clean session", c)
+			}
+			// MQTT 5 ends a session with its connection unless it has an expiry.
+			if c := b.connects[1]; c.clientID != "peril-client-bob" || c.clean || version == "5" && c.expiry == 0 {
+				t.Errorf("named connection: %+v, want its name and a persistent session", c)
+			}
+		})
+	}
+
+	if _, err := DialMQTT("mqtt://127.0.0.1:1?version=4", nil); err == nil || !strings.Contains(err.Error(), "unsupported MQTT version") {
+		t.Fatalf("err = %v, want an unsupported version", err)
+	}
+}
+
+func TestMQTTPublishAtUnsupported(t *testing.T) {
+	for _, version := range mqttVersions {
+		t.Run(version, func(t *testing.T) {
+			_, url := newTestMQTT(t, version, false)
+			conn := dialTestMQTT(t, url)
+			_, err := PublishAfter(context.Background(), conn, conn.ConfirmingPublisher(), time.Second, routing.ExchangePerilDirect, routing.PauseKey, "resume")
+			if !errors.Is(err, errors.ErrUnsupported) {
+				t.Fatalf("err = %v, want errors.ErrUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestMQTT5Properties(t *testing.T) {
+	_, url := newTestMQTT(t, "5", false)
+	conn := dialTestMQTT(t, url)
+	got := make(chan Envelope, 2)
+	_, err := SubscribeWithEnvelope(context.Background(), conn, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", Durable, func(log routing.GameLog, env Envelope) AckType {
+		if log.Username != "bob" {
+			t.Errorf("decoded %+v, want bob's log", log)
+		}
+		got <- env
+		if env.Redelivered {
+			return Ack
+		}
+		return NackRequeue
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Gob only decodes if the content type made it across, and a requeued
+	// copy keeps it too.
+	if err := PublishGob(conn.ConfirmingPublisher(), routing.ExchangePerilTopic, routing.GameLogSlug+".bob", routing.GameLog{Username: "bob"}, WithMessageID("log-1"), WithSchemaVersion("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, redelivered := range []bool{false, true} {
+		select {
+		case env := <-got:
+			if env.ContentType != ContentTypeGob || env.MessageID != "log-1" || env.CorrelationID != "log-1" || env.Producer == "" || env.SentAt.IsZero() {
+				t.Errorf("delivery %d lost its properties: %+v", i, env)
+			}
+			if env.SchemaVersion != "2" || env.RoutingKey != routing.GameLogSlug+".bob" || env.Redelivered != redelivered {
+				t.Errorf("delivery %d: schema version %q, key %q, redelivered %v", i, env.SchemaVersion, env.RoutingKey, env.Redelivered)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for delivery %d", i)
+		}
+	}
+}
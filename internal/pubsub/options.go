@@ -0,0 +1,199 @@
+package pubsub
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type subscribeOptions struct {
+	retry              RetryPolicy
+	defaultContentType string
+	dedup              DedupStore
+	concurrency        int
+	prefetchCount      int
+	orderedByKey       bool
+	middlewares        []any
+	decodeFailure      DecodeFailurePolicy
+	quarantineQueue    string
+	onDecodeError      func(env Envelope, err error)
+	queue              []QueueOption
+	bindings           [][2]string
+}
+
+type SubscribeOption func(*subscribeOptions)
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	options := subscribeOptions{
+		retry:              DefaultRetryPolicy,
+		defaultContentType: ContentTypeJSON,
+		concurrency:        1,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+func WithRetryPolicy(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = policy
+	}
+}
+
+// WithDefaultContentType picks the codec for deliveries that arrive without
+// a ContentType.
+func WithDefaultContentType(contentType string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.defaultContentType = contentType
+	}
+}
+
+// WithDeduplication skips deliveries whose message ID is already in store.
+// IDs are only recorded once a delivery is acked or discarded, so requeued
+// and retried messages still reach the handler.
+func WithDeduplication(store DedupStore) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.dedup = store
+	}
+}
+
+// WithConcurrency runs n handlers in parallel for one subscription.
+func WithConcurrency(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.concurrency = max(n, 1)
+	}
+}
+
+// WithPrefetch sets how many unacked deliveries the consumer may hold. By
+// default it is ten, or the concurrency if that is higher. There is no size
+// limit, since RabbitMQ rejects any prefetch size other than zero.
+func WithPrefetch(count int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.prefetchCount = count
+	}
+}
+
+// WithOrderedByRoutingKey keeps deliveries with the same routing key on the
+// same worker, so they are handled in the order they arrived. Requeued and
+// retried messages still come back out of order.
+func WithOrderedByRoutingKey() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.orderedByKey = true
+	}
+}
+
+// WithMiddleware wraps the subscription's handler. The middlewares must be
+// for the subscription's message type.
+func WithMiddleware[T any](mw ...Middleware[T]) SubscribeOption {
+	return func(o *subscribeOptions) {
+		for _, m := range mw {
+			o.middlewares = append(o.middlewares, m)
+		}
+	}
+}
+
+func WithDecodeFailurePolicy(policy DecodeFailurePolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.decodeFailure = policy
+	}
+}
+
+// WithQuarantineQueue overrides the queue QuarantineUndecodable parks
+// messages in.
+func WithQuarantineQueue(queue string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.quarantineQueue = queue
+	}
+}
+
+// OnDecodeError is called for every delivery that fails to decode, before
+// the decode failure policy is applied.
+func OnDecodeError(fn func(env Envelope, err error)) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.onDecodeError = fn
+	}
+}
+
+// WithQueueOptions declares the subscription's queue with opts.
+func WithQueueOptions(opts ...QueueOption) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.queue = append(o.queue, opts...)
+	}
+}
+
+// WithBinding also binds the subscription's queue to exchange with key, so
+// one queue, and one consumer, gets messages from several sources.
+// Priorities only order messages within a queue, so this is how control
+// messages overtake a backlog of other traffic.
+func WithBinding(exchange, key string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.bindings = append(o.bindings, [2]string{exchange, key})
+	}
+}
+
+type publishOptions struct {
+	contentType   string
+	schemaVersion string
+	causation     *Envelope
+	messageID     string
+	replyTo       string
+	expiration    string
+	priority      uint8
+	headers       amqp.Table
+}
+
+type PublishOption func(*publishOptions)
+
+func newPublishOptions(opts []PublishOption) publishOptions {
+	options := publishOptions{
+		contentType:   ContentTypeJSON,
+		schemaVersion: DefaultSchemaVersion,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+func WithContentType(contentType string) PublishOption {
+	return func(o *publishOptions) {
+		o.contentType = contentType
+	}
+}
+
+func WithSchemaVersion(version string) PublishOption {
+	return func(o *publishOptions) {
+		o.schemaVersion = version
+	}
+}
+
+// WithCausation marks the message as caused by the delivery described by
+// env, so it joins env's correlation chain.
+func WithCausation(env Envelope) PublishOption {
+	return func(o *publishOptions) {
+		o.causation = &env
+	}
+}
+
+// WithMessageID publishes the message with id instead of a random ID. A
+// deterministic ID lets a deduplicating subscriber drop a message that was
+// published twice.
+func WithMessageID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.messageID = id
+	}
+}
+
+// WithPriority publishes the message with priority p. Queues declared with
+// WithMaxPriority hand out higher priorities first, but only among messages
+// still waiting in the queue, not ones already prefetched by a consumer.
+func WithPriority(p uint8) PublishOption {
+	return func(o *publishOptions) {
+		o.priority = p
+	}
+}
+
+// WithRetain asks the broker to keep the message as the last known value of
+// its topic, for subscribers that arrive later. Only MQTT supports this.
+func WithRetain() PublishOption {
+	return withHeader(headerRetain, true)
+}
@@ -0,0 +1,140 @@
+package pubsub
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	decodeFailedReason = "decode-failed"
+
+	headerDecodeError        = "x-decode-error"
+	headerFailedAt           = "x-failed-at"
+	headerOriginalQueue      = "x-original-queue"
+	headerOriginalExchange   = "x-original-exchange"
+	headerOriginalRoutingKey = "x-original-routing-key"
+)
+
+var undecodableHeaders = []string{headerDecodeError, headerFailedAt, headerOriginalQueue, headerOriginalExchange, headerOriginalRoutingKey}
+
+// DecodeFailurePolicy decides what happens to a delivery that cannot be
+// decoded into the subscription's message type.
+type DecodeFailurePolicy int
+
+const (
+	// DeadLetterUndecodable sends the delivery to the dead-letter exchange
+	// with the decode error in its headers.
+	DeadLetterUndecodable DecodeFailurePolicy = iota
+	DiscardUndecodable
+	// QuarantineUndecodable parks the delivery in a separate queue, by
+	// default "<queue>.quarantine", that can be inspected like a
+	// dead-letter queue.
+	QuarantineUndecodable
+)
+
+type DecodeStats struct {
+	Failures     uint64
+	DeadLettered uint64
+	Discarded    uint64
+	Quarantined  uint64
+}
+
+type decodeCounters struct {
+	failures     atomic.Uint64
+	deadLettered atomic.Uint64
+	discarded    atomic.Uint64
+	quarantined  atomic.Uint64
+}
+
+func (c *decodeCounters) stats() DecodeStats {
+	return DecodeStats{
+		Failures:     c.failures.Load(),
+		DeadLettered: c.deadLettered.Load(),
+		Discarded:    c.discarded.Load(),
+		Quarantined:  c.quarantined.Load(),
+	}
+}
+
+// handleUndecodable settles a delivery that failed to decode according to
+// the subscription's policy. If the copy cannot be published, the delivery
+// is rejected so the broker dead-letters it instead.
+func handleUndecodable(ch Channel, queue string, d amqp.Delivery, decodeErr error, options subscribeOptions, counters *decodeCounters) {
+	counters.failures.Add(1)
+	decodeFailuresTotal.WithLabelValues(queue).Inc()
+	logger().Warn("unable to decode message", "queue", queue, "routing_key", d.RoutingKey, "message_id", d.MessageId, "err", decodeErr)
+	if options.onDecodeError != nil {
+		options.onDecodeError(newEnvelope(queue, d), decodeErr)
+	}
+
+	if options.decodeFailure == DiscardUndecodable {
+		counters.discarded.Add(1)
+		logSettleErr(queue, d, d.Ack(false))
+		return
+	}
+
+	headers := cloneTable(d.Headers)
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[headerDecodeError] = decodeErr.Error()
+	headers[headerFailedAt] = time.Now().UTC()
+	headers[headerOriginalQueue] = queue
+	headers[headerOriginalExchange] = d.Exchange
+	headers[headerOriginalRoutingKey] = d.RoutingKey
+
+	exchange, key := NewQueueSpec(options.queue...).deadLetterExchange(), d.RoutingKey
+	if options.decodeFailure == QuarantineUndecodable {
+		exchange, key = "", options.quarantineQueue
+		if key == "" {
+			key = queue + ".quarantine"
+		}
+		_, err := ch.QueueDeclare(key, true, false, false, false, nil)
+		if err != nil {
+			logger().Error("unable to declare quarantine queue", "queue", key, "err", err)
+			counters.deadLettered.Add(1)
+			logSettleErr(queue, d, d.Nack(false, false))
+			return
+		}
+	}
+
+	err := ch.PublishWithContext(context.Background(), exchange, key, false, false, publishingFrom(d, headers))
+	if err != nil {
+		logger().Error("unable to park undecodable message", "queue", queue, "message_id", d.MessageId, "err", err)
+		counters.deadLettered.Add(1)
+		logSettleErr(queue, d, d.Nack(false, false))
+		return
+	}
+	if options.decodeFailure == QuarantineUndecodable {
+		counters.quarantined.Add(1)
+	} else {
+		counters.deadLettered.Add(1)
+	}
+	logSettleErr(queue, d, d.Ack(false))
+}
+
+func logSettleErr(queue string, d amqp.Delivery, err error) {
+	if err != nil {
+		logger().Error("unable to settle delivery", "queue", queue, "message_id", d.MessageId, "err", err)
+	}
+}
+
+// undecodableDeadLetter fills in dl from the headers handleUndecodable
+// adds, as those messages carry no x-death header.
+func undecodableDeadLetter(dl DeadLetter) DeadLetter {
+	h := dl.Delivery.Headers
+	msg, ok := h[headerDecodeError].(string)
+	if !ok {
+		return dl
+	}
+	dl.Reason = decodeFailedReason
+	dl.Error = msg
+	dl.Count = 1
+	dl.Queue, _ = h[headerOriginalQueue].(string)
+	dl.Exchange, _ = h[headerOriginalExchange].(string)
+	dl.RoutingKey, _ = h[headerOriginalRoutingKey].(string)
+	dl.Time, _ = h[headerFailedAt].(time.Time)
+	return dl
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestDecodeFailurePolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy DecodeFailurePolicy
+		want   DecodeStats
+		// parked is the queue the undecodable message should end up in.
+		parked string
+	}{
+		{"dead-letter", DeadLetterUndecodable, DecodeStats{Failures: 1, DeadLettered: 1}, routing.DeadLetterQueue},
+		{"discard", DiscardUndecodable, DecodeStats{Failures: 1, Discarded: 1}, ""},
+		{"quarantine", QuarantineUndecodable, DecodeStats{Failures: 1, Quarantined: 1}, "quarantine.quarantine"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			conn := b.Connect()
+			if err := DeclareTopology(conn, PerilTopology(routing.DefaultExchanges())); err != nil {
+				t.Fatal(err)
+			}
+			var reported atomic.Int32
+			handled := make(chan int, 1)
+			sub, err := Subscribe(context.Background(), conn, routing.ExchangePerilTopic, tt.name, tt.name, Durable, func(n int) AckType {
+				handled <- n
+				return Ack
+			}, WithDecodeFailurePolicy(tt.policy), OnDecodeError(func(Envelope, error) { reported.Add(1) }))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ch, _ := conn.Channel()
+			PublishJSON(ch, routing.ExchangePerilTopic, tt.name, "not a number")
+			PublishJSON(ch, routing.ExchangePerilTopic, tt.name, 5)
+			select {
+			case n := <-handled:
+				if n != 5 {
+					t.Fatalf("handled %d, want 5", n)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the poison message blocked the queue")
+			}
+
+			if got := sub.DecodeStats(); got != tt.want {
+				t.Errorf("DecodeStats = %+v, want %+v", got, tt.want)
+			}
+			if n := reported.Load(); n != 1 {
+				t.Errorf("OnDecodeError called %d times, want 1", n)
+			}
+			if tt.parked == "" {
+				return
+			}
+			dls, err := InspectDeadLetters(conn, tt.parked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dls) != 1 {
+				t.Fatalf("%d messages in %s, want 1", len(dls), tt.parked)
+			}
+			if dls[0].Reason != decodeFailedReason || dls[0].Error == "" || dls[0].Queue != tt.name || dls[0].RoutingKey != tt.name {
+				t.Errorf("parked message: %+v, want the decode failure from %s", dls[0], tt.name)
+			}
+		})
+	}
+}
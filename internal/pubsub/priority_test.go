@@ -0,0 +1,56 @@
+package pubsub
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestPauseOvertakesQueuedMoves(t *testing.T) {
+	conn := newTestConnection(t, NewMemoryBroker())
+	if err := DeclareTopology(conn, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client's player queue: army moves plus pause on the same queue.
+	release := make(chan struct{})
+	handled := make(chan string, 10)
+	_, err := SubscribeWithEnvelope(context.Background(), conn, routing.ExchangePerilTopic, routing.PlayerPrefix+".bob", routing.ArmyMovesPrefix+".*", Transient, func(msg string, env Envelope) AckType {
+		if msg == "move 0" {
+			<-release
+		}
+		handled <- msg
+		return Ack
+	}, WithBinding(routing.ExchangePerilDirect, routing.PauseKey), WithQueueOptions(WithMaxPriority(routing.MaxPriority)), WithPrefetch(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := conn.Publisher()
+	moves := []string{"move 0", "move 1", "move 2", "move 3", "move 4"}
+	for _, move := range moves {
+		PublishJSON(pub, routing.ExchangePerilTopic, routing.ArmyMovesPrefix+".alice", move, WithPriority(routing.PriorityGameplay))
+	}
+	PublishJSON(pub, routing.ExchangePerilDirect, routing.PauseKey, "pause", WithPriority(routing.PriorityControl))
+	// Let the backlog settle in the queue behind the move being handled.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	var order []string
+	for len(order) < len(moves)+1 {
+		select {
+		case msg := <-handled:
+			order = append(order, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("handled %v, timed out waiting for the rest", order)
+		}
+	}
+	// Only the move already being handled comes before the pause.
+	want := []string{"move 0", "pause", "move 1", "move 2", "move 3", "move 4"}
+	if !slices.Equal(order, want) {
+		t.Fatalf("handled %v, want %v", order, want)
+	}
+}
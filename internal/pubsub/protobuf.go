@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const ContentTypeProtobuf = "application/x-protobuf"
+
+type protoConverter struct {
+	newMessage func() proto.Message
+	toProto    func(any) proto.Message
+	fromProto  func(proto.Message) any
+}
+
+var protoTypes sync.Map
+
+func init() {
+	RegisterCodec(protobufCodec{})
+}
+
+// RegisterProtoType lets the protobuf codec handle the plain Go type T by
+// converting it to and from the generated message P.
+func RegisterProtoType[T any, P proto.Message](to func(T) P, from func(P) T) {
+	var zero P
+	protoTypes.Store(reflect.TypeFor[T](), protoConverter{
+		newMessage: func() proto.Message {
+			return zero.ProtoReflect().Type().New().Interface()
+		},
+		toProto: func(v any) proto.Message {
+			return to(v.(T))
+		},
+		fromProto: func(m proto.Message) any {
+			return from(m.(P))
+		},
+	})
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	conv, ok := protoTypes.Load(reflect.TypeOf(v))
+	if !ok {
+		return nil, fmt.Errorf("pubsub: no protobuf message registered for %T", v)
+	}
+	return proto.Marshal(conv.(protoConverter).toProto(v))
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("pubsub: cannot unmarshal protobuf into %T", v)
+	}
+
+	// v is a pointer to the subscriber's T, which may itself be a generated
+	// message pointer.
+	if rv.Elem().Kind() == reflect.Pointer {
+		if _, ok := rv.Elem().Interface().(proto.Message); ok {
+			m := reflect.New(rv.Elem().Type().Elem()).Interface().(proto.Message)
+			err := proto.Unmarshal(data, m)
+			if err != nil {
+				return err
+			}
+			rv.Elem().Set(reflect.ValueOf(m))
+			return nil
+		}
+	}
+
+	conv, ok := protoTypes.Load(rv.Elem().Type())
+	if !ok {
+		return fmt.Errorf("pubsub: no protobuf message registered for %s", rv.Elem().Type())
+	}
+	c := conv.(protoConverter)
+	m := c.newMessage()
+	err := proto.Unmarshal(data, m)
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(reflect.ValueOf(c.fromProto(m)))
+	return nil
+}
@@ -1,12 +1,13 @@
 package pubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
+	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"log"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
 )
 
 type SimpleQueueType int
@@ -14,6 +15,10 @@ type SimpleQueueType int
 const (
 	Durable SimpleQueueType = iota
 	Transient
+	// AutoDelete is a queue every consumer shares that survives none of
+	// them: it is deleted once the last consumer goes away, so publishers
+	// see it as unroutable instead of filling an orphaned queue.
+	AutoDelete
 )
 
 type AckType int
@@ -22,128 +27,342 @@ const (
 	Ack AckType = iota
 	NackRequeue
 	NackDiscard
+	// RetryLater acks the delivery and redelivers a copy through a delayed
+	// retry queue, see RetryPolicy.
+	RetryLater
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	data, err := json.Marshal(val)
-	if err != nil {
-		return err
+func (a AckType) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case NackRequeue:
+		return "nack requeue"
+	case NackDiscard:
+		return "nack discard"
+	case RetryLater:
+		return "retry later"
 	}
-	return ch.PublishWithContext(
-		context.Background(),
-		exchange,
-		key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        data},
-	)
+	return fmt.Sprintf("AckType(%d)", int(a))
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(val)
+// Publish encodes val with the codec chosen by WithContentType, JSON by
+// default, and publishes it.
+func Publish[T any](ctx context.Context, pub Publisher, exchange, key string, val T, opts ...PublishOption) error {
+	options := newPublishOptions(opts)
+	codec, err := CodecFor(options.contentType)
+	if err != nil {
+		return err
+	}
+	data, err := codec.Marshal(val)
 	if err != nil {
 		return err
 	}
-	return ch.PublishWithContext(
-		context.Background(),
+	msg := amqp.Publishing{
+		ContentType: codec.ContentType(),
+		Priority:    options.priority,
+		Body:        data,
+	}
+	stampEnvelope(&msg, options)
+	ctx, span := startPublishSpan(ctx, exchange, key, &msg)
+	err = pub.PublishWithContext(
+		ctx,
 		exchange,
 		key,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: "application/gob",
-			Body:        buf.Bytes()},
+		msg,
 	)
+	observePublish(exchange, key, err)
+	endSpan(span, err)
+	return err
 }
 
-func DeclareAndBind(conn *amqp.Connection, exchange, queueName, key string, simpleQueueType SimpleQueueType) (*amqp.Channel, amqp.Queue, error) {
-	ch, err := conn.Channel()
+func PublishJSON[T any](pub Publisher, exchange, key string, val T, opts ...PublishOption) error {
+	return PublishJSONWithContext(context.Background(), pub, exchange, key, val, opts...)
+}
+
+func PublishJSONWithContext[T any](ctx context.Context, pub Publisher, exchange, key string, val T, opts ...PublishOption) error {
+	return Publish(ctx, pub, exchange, key, val, append(slices.Clip(opts), WithContentType(ContentTypeJSON))...)
+}
+
+func PublishGob[T any](pub Publisher, exchange, key string, val T, opts ...PublishOption) error {
+	return PublishGobWithContext(context.Background(), pub, exchange, key, val, opts...)
+}
+
+func PublishGobWithContext[T any](ctx context.Context, pub Publisher, exchange, key string, val T, opts ...PublishOption) error {
+	return Publish(ctx, pub, exchange, key, val, append(slices.Clip(opts), WithContentType(ContentTypeGob))...)
+}
+
+// DeclareAndBind declares a queue and binds it to exchange. The queue
+// options are checked before anything is sent to the broker.
+func DeclareAndBind(b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, opts ...QueueOption) (Channel, amqp.Queue, error) {
+	spec := NewQueueSpec(opts...)
+	err := spec.validateFor(simpleQueueType)
+	if err != nil {
+		return nil, amqp.Queue{}, fmt.Errorf("pubsub: queue %s: %w", queueName, err)
+	}
+
+	ch, err := b.Channel()
 	if err != nil {
 		return nil, amqp.Queue{}, err
 	}
 
 	durable := simpleQueueType == Durable
-	autoDelete := simpleQueueType == Transient
+	autoDelete := simpleQueueType != Durable
 	exclusive := simpleQueueType == Transient
-	table := amqp.Table{
-		"x-dead-letter-exchange": "peril_dlx",
-	}
-	queue, err := ch.QueueDeclare(queueName, durable, autoDelete, exclusive, false, table)
+	queue, err := ch.QueueDeclare(queueName, durable, autoDelete, exclusive, false, spec.args())
 	if err != nil {
+		ch.Close()
 		return nil, amqp.Queue{}, err
 	}
 
 	err = ch.QueueBind(queue.Name, key, exchange, false, nil)
 	if err != nil {
+		ch.Close()
 		return nil, amqp.Queue{}, err
 	}
 
 	return ch, queue, nil
 }
 
-func SubscribeToJSON[T any](conn *amqp.Connection, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType) error {
-	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, func(data []byte) (T, error) {
-		var msg T
-		err := json.Unmarshal(data, &msg)
-		return msg, err
-	})
+func SubscribeToJSON[T any](b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType, opts ...SubscribeOption) error {
+	_, err := SubscribeToJSONWithContext(context.Background(), b, exchange, queueName, key, simpleQueueType, handler, opts...)
+	return err
 }
 
-func SubscribeToGob[T any](conn *amqp.Connection, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType) error {
-	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, func(data []byte) (T, error) {
-		buf := bytes.NewBuffer(data)
-		dec := gob.NewDecoder(buf)
-		var msg T
-		err := dec.Decode(&msg)
-		return msg, err
-	})
+func SubscribeToJSONWithContext[T any](ctx context.Context, b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	opts = append([]SubscribeOption{WithDefaultContentType(ContentTypeJSON)}, opts...)
+	return Subscribe(ctx, b, exchange, queueName, key, simpleQueueType, handler, opts...)
 }
 
-func subscribe[T any](conn *amqp.Connection, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType, unmarshaller func([]byte) (T, error)) error {
-	ch, _, err := DeclareAndBind(conn, exchange, queueName, key, simpleQueueType)
-	if err != nil {
-		return err
+func SubscribeToGob[T any](b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType, opts ...SubscribeOption) error {
+	_, err := SubscribeToGobWithContext(context.Background(), b, exchange, queueName, key, simpleQueueType, handler, opts...)
+	return err
+}
+
+func SubscribeToGobWithContext[T any](ctx context.Context, b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	opts = append([]SubscribeOption{WithDefaultContentType(ContentTypeGob)}, opts...)
+	return Subscribe(ctx, b, exchange, queueName, key, simpleQueueType, handler, opts...)
+}
+
+// Subscribe consumes from a queue, decoding each delivery with the codec
+// registered for its ContentType. Deliveries without a content type fall
+// back to WithDefaultContentType, JSON by default.
+func Subscribe[T any](ctx context.Context, b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, b, exchange, queueName, key, simpleQueueType, func(_ context.Context, msg T, _ Envelope) AckType {
+		return handler(msg)
+	}, opts)
+}
+
+// SubscribeWithEnvelope is like Subscribe, but the handler also receives the
+// envelope the message was published with.
+func SubscribeWithEnvelope[T any](ctx context.Context, b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(T, Envelope) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, b, exchange, queueName, key, simpleQueueType, func(_ context.Context, msg T, env Envelope) AckType {
+		return handler(msg, env)
+	}, opts)
+}
+
+// SubscribeHandler is like SubscribeWithEnvelope for a Handler. Its context
+// carries the values of ctx but is not cancelled along with it, so
+// in-flight messages can finish during shutdown.
+func SubscribeHandler[T any](ctx context.Context, b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler Handler[T], opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, b, exchange, queueName, key, simpleQueueType, handler, opts)
+}
+
+func subscribe[T any](ctx context.Context, b Broker, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler Handler[T], opts []SubscribeOption) (*Subscription, error) {
+	options := newSubscribeOptions(opts)
+	declare := func(b Broker) (Channel, amqp.Queue, error) {
+		ch, queue, err := DeclareAndBind(b, exchange, queueName, key, simpleQueueType, options.queue...)
+		if err != nil {
+			return nil, amqp.Queue{}, err
+		}
+		for _, binding := range options.bindings {
+			err = ch.QueueBind(queue.Name, binding[1], binding[0], false, nil)
+			if err != nil {
+				ch.Close()
+				return nil, amqp.Queue{}, err
+			}
+		}
+		return ch, queue, nil
 	}
+	return consumeQueue(ctx, b, declare, nil, handler, options)
+}
 
-	err = ch.Qos(10, 0, true)
+// consumeQueue runs handler on the queue returned by declare, redeclaring
+// and consuming again after each reconnect. consumeArgs, if set, is called
+// for every Consume.
+func consumeQueue[T any](ctx context.Context, b Broker, declare func(Broker) (Channel, amqp.Queue, error), consumeArgs func() amqp.Table, handler Handler[T], options subscribeOptions) (*Subscription, error) {
+	h, err := buildHandler(handler, options.middlewares)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	handlerCtx := context.WithoutCancel(ctx)
+	sub := newSubscription()
+	start := func(b Broker) error {
+		if sub.isClosed() {
+			return nil
+		}
+
+		ch, queue, err := declare(b)
+		if err != nil {
+			return err
+		}
+
+		prefetch := options.prefetchCount
+		if prefetch == 0 {
+			prefetch = max(10, options.concurrency)
+		}
+		err = ch.Qos(prefetch, 0, false)
+		if err != nil {
+			ch.Close()
+			return err
+		}
+		// Retries are published with confirms on a channel of their own,
+		// since the consumer's channel is not in confirm mode.
+		retryCh, err := b.Channel()
+		if err != nil {
+			ch.Close()
+			return err
+		}
+		retry, err := NewConfirmingPublisher(retryCh)
+		if err != nil {
+			retryCh.Close()
+			ch.Close()
+			return err
+		}
+
+		tag := newConsumerTag(queue.Name)
+		var args amqp.Table
+		if consumeArgs != nil {
+			args = consumeArgs()
+		}
+		deliveries, err := ch.Consume(queue.Name, tag, false, false, false, false, args)
+		if err != nil {
+			retry.Close()
+			ch.Close()
+			return err
+		}
+
+		if !sub.attach(ch, tag) {
+			retry.Close()
+			ch.Close()
+			return nil
+		}
+		go func() {
+			defer sub.detach()
+			consume[T](handlerCtx, ch, retry, queue.Name, deliveries, h, options, &sub.decodeCounters)
+		}()
+		return nil
 	}
 
-	deliveries, err := ch.Consume(queueName, "", false, false, false, false, nil)
+	err = start(b)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if r, ok := b.(recoverer); ok {
+		sub.onClose(r.onRecover(start))
 	}
+	sub.closeOnDone(ctx)
+	return sub, nil
+}
 
-	go func() {
-		defer ch.Close()
+func consume[T any](ctx context.Context, ch Channel, retry *ConfirmingPublisher, queue string, deliveries <-chan amqp.Delivery, handler Handler[any], options subscribeOptions, counters *decodeCounters) {
+	defer ch.Close()
+	defer retry.Close()
+
+	var wg sync.WaitGroup
+	work := func(deliveries <-chan amqp.Delivery) {
+		defer wg.Done()
 		for d := range deliveries {
-			msg, err := unmarshaller(d.Body)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
+			handle[T](ctx, ch, retry, queue, d, handler, options, counters)
+		}
+	}
 
-			switch handler(msg) {
-			case Ack:
-				log.Println("Responding with ack")
-				err = d.Ack(false)
-			case NackRequeue:
-				log.Println("Responding with nack requeue")
-				err = d.Nack(false, true)
-			case NackDiscard:
-				log.Println("Responding with nack discard")
-				err = d.Nack(false, false)
-			}
-			if err != nil {
-				log.Println(err)
-			}
+	if !options.orderedByKey {
+		for i := 0; i < options.concurrency; i++ {
+			wg.Add(1)
+			go work(deliveries)
 		}
-	}()
+		wg.Wait()
+		return
+	}
+
+	lanes := make([]chan amqp.Delivery, options.concurrency)
+	for i := range lanes {
+		lanes[i] = make(chan amqp.Delivery)
+		wg.Add(1)
+		go work(lanes[i])
+	}
+	for d := range deliveries {
+		h := fnv.New32a()
+		h.Write([]byte(d.RoutingKey))
+		lanes[h.Sum32()%uint32(len(lanes))] <- d
+	}
+	for _, lane := range lanes {
+		close(lane)
+	}
+	wg.Wait()
+}
+
+func handle[T any](ctx context.Context, ch Channel, retry *ConfirmingPublisher, queue string, d amqp.Delivery, handler Handler[any], options subscribeOptions, counters *decodeCounters) {
+	dedupKey := ""
+	if options.dedup != nil && d.MessageId != "" {
+		dedupKey = queue + "/" + d.MessageId
+		seen, err := options.dedup.Seen(dedupKey)
+		if err != nil {
+			logger().Error("unable to check for duplicate", "queue", queue, "message_id", d.MessageId, "err", err)
+		}
+		if seen {
+			logger().Info("skipping duplicate message", "queue", queue, "message_id", d.MessageId)
+			duplicatesTotal.WithLabelValues(queue).Inc()
+			logSettleErr(queue, d, d.Ack(false))
+			return
+		}
+	}
+
+	msg, err := decode[T](d, options.defaultContentType)
+	if err != nil {
+		handleUndecodable(ch, queue, d, err, options, counters)
+		return
+	}
 
-	return nil
+	ctx, span := startConsumeSpan(ctx, queue, d)
+	defer span.End()
+	start := time.Now()
+	ack := handler(ctx, msg, newEnvelope(queue, d))
+	observeHandled(queue, d.RoutingKey, ack, time.Since(start))
+	recordAck(span, ack)
+	// Record the ID before settling, so a crash in between leads to a
+	// skipped redelivery rather than a second run of the handler.
+	if dedupKey != "" && (ack == Ack || ack == NackDiscard) {
+		if err := options.dedup.Mark(dedupKey); err != nil {
+			logger().Error("unable to record handled message", "queue", queue, "message_id", d.MessageId, "err", err)
+		}
+	}
+	switch ack {
+	case Ack:
+		err = d.Ack(false)
+	case NackRequeue:
+		err = d.Nack(false, true)
+	case NackDiscard:
+		err = d.Nack(false, false)
+	case RetryLater:
+		err = retryLater(retry, queue, d, options.retry)
+	}
+	logSettleErr(queue, d, err)
+}
+
+func decode[T any](d amqp.Delivery, defaultContentType string) (T, error) {
+	var msg T
+	contentType := d.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return msg, err
+	}
+	err = codec.Unmarshal(d.Body, &msg)
+	return msg, err
 }
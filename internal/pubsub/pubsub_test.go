@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestSubscribeConcurrencyOrdered(t *testing.T) {
+	const (
+		keys     = 8
+		messages = 40
+		work     = 20 * time.Millisecond
+	)
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeTopic, true, false, false, false, nil)
+
+	var (
+		mu    sync.Mutex
+		order = map[string][]int{}
+		wg    sync.WaitGroup
+	)
+	wg.Add(messages)
+	_, err := SubscribeWithEnvelope(context.Background(), conn, "x", "q", "#", Durable, func(n int, env Envelope) AckType {
+		defer wg.Done()
+		time.Sleep(work)
+		mu.Lock()
+		order[env.RoutingKey] = append(order[env.RoutingKey], n)
+		mu.Unlock()
+		return Ack
+	}, WithConcurrency(keys), WithOrderedByRoutingKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < messages; i++ {
+		PublishJSON(ch, "x", fmt.Sprintf("k%d", i%keys), i)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed >= messages*work/2 {
+		t.Errorf("handling took %s, want the keys handled in parallel", elapsed)
+	}
+	for key, got := range order {
+		if !slices.IsSorted(got) {
+			t.Errorf("%s handled out of order: %v", key, got)
+		}
+	}
+}
+
+func TestSubscribePrefetch(t *testing.T) {
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeTopic, true, false, false, false, nil)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	_, err := Subscribe(context.Background(), conn, "x", "q", "#", Durable, func(int) AckType {
+		started <- struct{}{}
+		<-release
+		return Ack
+	}, WithConcurrency(4), WithPrefetch(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+	for i := 0; i < 5; i++ {
+		PublishJSON(ch, "x", "k", i)
+	}
+
+	// Four workers are free, but only two deliveries are handed over
+	// before an ack.
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+	select {
+	case <-started:
+		t.Fatal("handler started beyond the prefetch count")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPublishLeavesCallerOptionsAlone(t *testing.T) {
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeDirect, true, false, false, false, nil)
+	// Spare capacity is where an append would write.
+	opts := make([]PublishOption, 1, 2)
+	opts[0] = WithPriority(1)
+
+	PublishJSON(ch, "x", "k", 1, opts...)
+	PublishGob(ch, "x", "k", 1, opts...)
+	PublishAfter(context.Background(), conn, ch, time.Millisecond, "x", "k", 1, opts...)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	Call[int, int](ctx, conn, "x", "k", 1, opts...)
+	if opts[:2][1] != nil {
+		t.Fatal("a publish wrote its own option into the caller's slice")
+	}
+}
@@ -0,0 +1,240 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type QueueType string
+
+const (
+	QueueClassic QueueType = "classic"
+	QueueQuorum  QueueType = "quorum"
+	QueueStream  QueueType = queueTypeStream
+)
+
+// Overflow is what a queue does once it reaches its maximum length.
+type Overflow string
+
+const (
+	OverflowDropHead         Overflow = "drop-head"
+	OverflowRejectPublish    Overflow = "reject-publish"
+	OverflowRejectPublishDLX Overflow = "reject-publish-dlx"
+)
+
+// QueueSpec describes the RabbitMQ arguments a queue is declared with. The
+// zero value is a classic queue with no limits, which is what every queue
+// was before queue options existed.
+type QueueSpec struct {
+	Type                 QueueType
+	Lazy                 bool
+	MaxLength            int64
+	MaxLengthBytes       int64
+	Overflow             Overflow
+	MessageTTL           time.Duration
+	Expires              time.Duration
+	SingleActiveConsumer bool
+	MaxPriority          uint8
+	// DeadLetterExchange is where rejected and expired messages go,
+	// routing.ExchangePerilDLX if empty.
+	DeadLetterExchange string
+}
+
+type QueueOption func(*QueueSpec)
+
+func NewQueueSpec(opts ...QueueOption) QueueSpec {
+	var spec QueueSpec
+	for _, opt := range opts {
+		opt(&spec)
+	}
+	return spec
+}
+
+func WithQueueType(t QueueType) QueueOption {
+	return func(s *QueueSpec) {
+		s.Type = t
+	}
+}
+
+// WithLazyMode keeps a classic queue's messages on disk rather than in
+// memory.
+func WithLazyMode() QueueOption {
+	return func(s *QueueSpec) {
+		s.Lazy = true
+	}
+}
+
+func WithMaxLength(n int64) QueueOption {
+	return func(s *QueueSpec) {
+		s.MaxLength = n
+	}
+}
+
+func WithMaxLengthBytes(n int64) QueueOption {
+	return func(s *QueueSpec) {
+		s.MaxLengthBytes = n
+	}
+}
+
+// WithOverflow sets what happens to a full queue. RabbitMQ drops the oldest
+// message by default.
+func WithOverflow(o Overflow) QueueOption {
+	return func(s *QueueSpec) {
+		s.Overflow = o
+	}
+}
+
+// WithMessageTTL dead-letters messages that have waited in the queue for
+// longer than d.
+func WithMessageTTL(d time.Duration) QueueOption {
+	return func(s *QueueSpec) {
+		s.MessageTTL = d
+	}
+}
+
+// WithQueueExpiry deletes the queue once it has gone unused for d.
+func WithQueueExpiry(d time.Duration) QueueOption {
+	return func(s *QueueSpec) {
+		s.Expires = d
+	}
+}
+
+// WithSingleActiveConsumer delivers to one consumer at a time, failing over
+// to the next when it goes away, so messages are handled in order.
+func WithSingleActiveConsumer() QueueOption {
+	return func(s *QueueSpec) {
+		s.SingleActiveConsumer = true
+	}
+}
+
+// WithMaxPriority enables message priorities from 0 up to n on a classic
+// queue.
+func WithMaxPriority(n uint8) QueueOption {
+	return func(s *QueueSpec) {
+		s.MaxPriority = n
+	}
+}
+
+// WithDeadLetterExchange dead-letters to exchange instead of
+// routing.ExchangePerilDLX.
+func WithDeadLetterExchange(exchange string) QueueOption {
+	return func(s *QueueSpec) {
+		s.DeadLetterExchange = exchange
+	}
+}
+
+func (s QueueSpec) deadLetterExchange() string {
+	if s.DeadLetterExchange == "" {
+		return routing.ExchangePerilDLX
+	}
+	return s.DeadLetterExchange
+}
+
+// Validate reports combinations RabbitMQ would reject, or silently ignore,
+// for the spec's queue type.
+func (s QueueSpec) Validate() error {
+	var errs []error
+	switch s.Type {
+	case "", QueueClassic, QueueQuorum, QueueStream:
+	default:
+		errs = append(errs, fmt.Errorf("unknown queue type %q", s.Type))
+	}
+	switch s.Overflow {
+	case "", OverflowDropHead, OverflowRejectPublish, OverflowRejectPublishDLX:
+	default:
+		errs = append(errs, fmt.Errorf("unknown overflow behaviour %q", s.Overflow))
+	}
+	if s.MaxLength < 0 || s.MaxLengthBytes < 0 || s.MessageTTL < 0 || s.Expires < 0 {
+		errs = append(errs, errors.New("limits must not be negative"))
+	}
+	if s.Overflow != "" && s.MaxLength == 0 && s.MaxLengthBytes == 0 {
+		errs = append(errs, errors.New("overflow needs a max length"))
+	}
+
+	unsupported := func(feature string) {
+		errs = append(errs, fmt.Errorf("%s queues do not support %s", s.Type, feature))
+	}
+	switch s.Type {
+	case QueueQuorum:
+		if s.Lazy {
+			unsupported("lazy mode")
+		}
+		if s.MaxPriority > 0 {
+			unsupported("priorities")
+		}
+		if s.Overflow == OverflowRejectPublishDLX {
+			unsupported("reject-publish-dlx overflow")
+		}
+	case QueueStream:
+		if s.Lazy {
+			unsupported("lazy mode")
+		}
+		if s.MaxPriority > 0 {
+			unsupported("priorities")
+		}
+		if s.MaxLength > 0 {
+			unsupported("a max length in messages")
+		}
+		if s.Overflow != "" {
+			unsupported("overflow behaviours")
+		}
+		if s.MessageTTL > 0 {
+			unsupported("message TTLs")
+		}
+		if s.Expires > 0 {
+			unsupported("expiry")
+		}
+		if s.SingleActiveConsumer {
+			unsupported("single active consumer over AMQP")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s QueueSpec) validateFor(simpleQueueType SimpleQueueType) error {
+	err := s.Validate()
+	if simpleQueueType != Durable && (s.Type == QueueQuorum || s.Type == QueueStream) {
+		err = errors.Join(err, fmt.Errorf("%s queues must be durable", s.Type))
+	}
+	return err
+}
+
+func (s QueueSpec) args() amqp.Table {
+	args := amqp.Table{}
+	// Streams keep every message, so there is nothing to dead-letter.
+	if s.Type != QueueStream {
+		args["x-dead-letter-exchange"] = s.deadLetterExchange()
+	}
+	if s.Type != "" {
+		args["x-queue-type"] = string(s.Type)
+	}
+	if s.Lazy {
+		args["x-queue-mode"] = "lazy"
+	}
+	if s.MaxLength > 0 {
+		args["x-max-length"] = s.MaxLength
+	}
+	if s.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = s.MaxLengthBytes
+	}
+	if s.Overflow != "" {
+		args["x-overflow"] = string(s.Overflow)
+	}
+	if s.MessageTTL > 0 {
+		args["x-message-ttl"] = s.MessageTTL.Milliseconds()
+	}
+	if s.Expires > 0 {
+		args["x-expires"] = s.Expires.Milliseconds()
+	}
+	if s.SingleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+	if s.MaxPriority > 0 {
+		args["x-max-priority"] = int64(s.MaxPriority)
+	}
+	return args
+}
@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestQueueSpecValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []QueueOption
+		// wantErr is part of the error message, or empty if the spec is valid.
+		wantErr string
+	}{
+		{"classic default", nil, ""},
+		{"quorum with limits", []QueueOption{WithQueueType(QueueQuorum), WithMaxLength(10), WithOverflow(OverflowRejectPublish), WithSingleActiveConsumer(), WithMessageTTL(time.Minute)}, ""},
+		{"lazy classic with priorities", []QueueOption{WithLazyMode(), WithMaxPriority(5)}, ""},
+		{"stream with byte limit", []QueueOption{WithQueueType(QueueStream), WithMaxLengthBytes(1 << 20)}, ""},
+		{"unknown type", []QueueOption{WithQueueType("bogus")}, `unknown queue type "bogus"`},
+		{"unknown overflow", []QueueOption{WithMaxLength(1), WithOverflow("drop-tail")}, `unknown overflow behaviour "drop-tail"`},
+		{"overflow without max length", []QueueOption{WithOverflow(OverflowDropHead)}, "overflow needs a max length"},
+		{"negative limit", []QueueOption{WithMaxLength(-1)}, "limits must not be negative"},
+		{"lazy quorum", []QueueOption{WithQueueType(QueueQuorum), WithLazyMode()}, "quorum queues do not support lazy mode"},
+		{"quorum priorities", []QueueOption{WithQueueType(QueueQuorum), WithMaxPriority(5)}, "quorum queues do not support priorities"},
+		{"quorum reject-publish-dlx", []QueueOption{WithQueueType(QueueQuorum), WithMaxLength(1), WithOverflow(OverflowRejectPublishDLX)}, "reject-publish-dlx"},
+		{"stream max length", []QueueOption{WithQueueType(QueueStream), WithMaxLength(5)}, "stream queues do not support a max length in messages"},
+		{"stream TTL", []QueueOption{WithQueueType(QueueStream), WithMessageTTL(time.Second)}, "stream queues do not support message TTLs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewQueueSpec(tt.opts...).Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueueSpecArgs(t *testing.T) {
+	args := NewQueueSpec(WithQueueType(QueueQuorum), WithMaxLength(10), WithOverflow(OverflowRejectPublish),
+		WithSingleActiveConsumer(), WithMessageTTL(time.Minute), WithQueueExpiry(time.Hour)).args()
+	want := amqp.Table{
+		"x-dead-letter-exchange":   routing.ExchangePerilDLX,
+		"x-queue-type":             "quorum",
+		"x-max-length":             int64(10),
+		"x-overflow":               "reject-publish",
+		"x-single-active-consumer": true,
+		"x-message-ttl":            int64(60000),
+		"x-expires":                int64(3600000),
+	}
+	if len(args) != len(want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+	for k, v := range want {
+		if args[k] != v {
+			t.Errorf("%s = %v (%T), want %v (%T)", k, args[k], args[k], v, v)
+		}
+	}
+
+	if dlx := NewQueueSpec(WithDeadLetterExchange("game_dlx")).args()["x-dead-letter-exchange"]; dlx != "game_dlx" {
+		t.Errorf("x-dead-letter-exchange = %v, want the configured game_dlx", dlx)
+	}
+	if _, ok := NewQueueSpec(WithQueueType(QueueStream)).args()["x-dead-letter-exchange"]; ok {
+		t.Error("a stream is declared with a dead letter exchange")
+	}
+}
+
+// unreachableBroker fails every attempt to use it.
+type unreachableBroker struct{}
+
+var errUnreachable = errors.New("broker contacted")
+
+func (unreachableBroker) Channel() (Channel, error) { return nil, errUnreachable }
+func (unreachableBroker) Close() error              { return nil }
+
+func TestDeclareAndBindValidatesFirst(t *testing.T) {
+	_, _, err := DeclareAndBind(unreachableBroker{}, "peril_topic", "war", "war.*", Transient, WithQueueType(QueueQuorum))
+	if err == nil || errors.Is(err, errUnreachable) || !strings.Contains(err.Error(), "quorum queues must be durable") {
+		t.Fatalf("err = %v, want a validation error before the broker is contacted", err)
+	}
+
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil)
+	_, q, err := DeclareAndBind(conn, "peril_topic", "war", "war.*", Durable, WithQueueType(QueueQuorum), WithMaxLength(10), WithOverflow(OverflowRejectPublish))
+	if err != nil || q.Name != "war" {
+		t.Fatalf("DeclareAndBind = %v, %v", q, err)
+	}
+	// Redeclaring with another type is refused by the broker.
+	_, _, err = DeclareAndBind(conn, "peril_topic", "war", "war.*", Durable)
+	if err == nil {
+		t.Fatal("redeclared a quorum queue as a classic queue")
+	}
+}
@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const retryAttemptHeader = "x-retry-attempt"
+
+// RetryPolicy controls how RetryLater redelivers a message: attempt n waits
+// BaseDelay*2^(n-1), capped at MaxDelay, and once MaxAttempts retries have
+// been used up the message is dead-lettered instead.
+type RetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+	MaxAttempts: 5,
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// retryLater parks a copy of d in a per-delay retry queue whose TTL
+// dead-letters it back onto the original queue, then acks d. The copy goes
+// out through pub, so d is only acked once the broker has confirmed it. The
+// retry queue is declared on pub's channel too, since STOMP only knows a
+// queue on the channel that declared it.
+func retryLater(pub *ConfirmingPublisher, queue string, d amqp.Delivery, policy RetryPolicy) error {
+	attempt := RetryAttempt(d) + 1
+	if attempt > policy.MaxAttempts {
+		return d.Nack(false, false)
+	}
+
+	delay := policy.delay(attempt)
+	retryQueue := fmt.Sprintf("%s.retry.%d", queue, delay.Milliseconds())
+	_, err := pub.ch.QueueDeclare(retryQueue, true, false, false, false, amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queue,
+		"x-expires":                 (delay + time.Minute).Milliseconds(),
+	})
+	if err != nil {
+		d.Nack(false, true)
+		return err
+	}
+
+	headers := cloneTable(d.Headers)
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[retryAttemptHeader] = int64(attempt)
+	err = pub.PublishWithContext(context.Background(), "", retryQueue, false, false, publishingFrom(d, headers))
+	if err != nil {
+		d.Nack(false, true)
+		return err
+	}
+	return d.Ack(false)
+}
+
+// RetryAttempt reports how many times d has already been retried.
+func RetryAttempt(d amqp.Delivery) int {
+	n, _ := tableInt(d.Headers, retryAttemptHeader)
+	return int(n)
+}
+
+func tableInt(t amqp.Table, key string) (int64, bool) {
+	switch v := t[key].(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case string:
+		// Transports without typed headers, such as STOMP, carry numbers
+		// as text.
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,86 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestRetryLaterBacksOffThenDeadLetters(t *testing.T) {
+	b := NewMemoryBroker()
+	conn := newTestConnection(t, b)
+	if err := DeclareTopology(conn, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+
+	type attempt struct {
+		n  int64
+		at time.Time
+	}
+	attempts := make(chan attempt, 10)
+	policy := RetryPolicy{BaseDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond, MaxAttempts: 3}
+	sub, err := SubscribeWithEnvelope(context.Background(), conn, routing.ExchangePerilTopic, "flaky", "flaky.*", Durable, func(_ int, env Envelope) AckType {
+		n, _ := tableInt(env.Headers, retryAttemptHeader)
+		attempts <- attempt{n, time.Now()}
+		return RetryLater
+	}, WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := PublishJSON(conn.ConfirmingPublisher(), routing.ExchangePerilTopic, "flaky.bob", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first delivery and then one per retry.
+	var got []attempt
+	for len(got) < policy.MaxAttempts+1 {
+		select {
+		case a := <-attempts:
+			got = append(got, a)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("handled %d times, want %d", len(got), policy.MaxAttempts+1)
+		}
+	}
+	for i, a := range got {
+		if a.n != int64(i) {
+			t.Errorf("delivery %d has retry attempt %d", i, a.n)
+		}
+	}
+	// The delay doubles from BaseDelay until it reaches MaxDelay.
+	for i, want := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond} {
+		if wait := got[i+1].at.Sub(got[i].at); wait < want {
+			t.Errorf("retry %d came after %v, want at least %v", i+1, wait, want)
+		}
+	}
+
+	dls := waitForDeadLetters(t, conn, 1)
+	if dls[0].Queue != "flaky" || dls[0].Reason != "rejected" {
+		t.Errorf("dead letter from %q because %q, want flaky rejected", dls[0].Queue, dls[0].Reason)
+	}
+	select {
+	case a := <-attempts:
+		t.Fatalf("handled again after the last retry: attempt %d", a.n)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var delayQueues []string
+	for name := range b.queues {
+		if strings.HasPrefix(name, "flaky.retry.") {
+			delayQueues = append(delayQueues, name)
+		}
+	}
+	for _, name := range []string{"flaky.retry.20", "flaky.retry.40", "flaky.retry.50"} {
+		if b.queues[name] == nil {
+			t.Errorf("delay queue %s was not used; have %v", name, delayQueues)
+		}
+	}
+	if len(delayQueues) != 3 {
+		t.Errorf("delay queues %v, want one per delay", delayQueues)
+	}
+}
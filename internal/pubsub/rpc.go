@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// directReplyTo is RabbitMQ's pseudo queue for replies, which needs no
+	// queue declaration per caller.
+	directReplyTo = "amq.rabbitmq.reply-to"
+
+	headerRPCError = "x-rpc-error"
+
+	defaultCallTimeout = 5 * time.Second
+)
+
+// RemoteError is returned by Call when the server's handler failed.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "pubsub: remote error: " + e.Message
+}
+
+// Call publishes req and waits for the matching reply from Serve. Without a
+// deadline on ctx it gives up after five seconds, and the request expires
+// from the queue once nobody is waiting for it anymore. A *ReturnedError
+// means no server is listening on key.
+func Call[Req, Resp any](ctx context.Context, b Broker, exchange, key string, req Req, opts ...PublishOption) (Resp, error) {
+	var resp Resp
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCallTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	ch, err := b.Channel()
+	if err != nil {
+		return resp, err
+	}
+	defer ch.Close()
+
+	// The reply consumer must exist before the request is published.
+	replies, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return resp, err
+	}
+	pub, err := NewConfirmingPublisher(ch)
+	if err != nil {
+		return resp, err
+	}
+
+	id := NewMessageID()
+	ttl := time.Until(deadline).Milliseconds()
+	if ttl < 1 {
+		ttl = 1
+	}
+	opts = append(slices.Clip(opts), WithMessageID(id), withReplyTo(directReplyTo), withExpiration(ttl))
+	err = Publish(ctx, pub, exchange, key, req, opts...)
+	if err != nil {
+		return resp, err
+	}
+
+	for {
+		select {
+		case d, ok := <-replies:
+			if !ok {
+				return resp, amqp.ErrClosed
+			}
+			env := newEnvelope(directReplyTo, d)
+			if env.CausationID != id {
+				continue
+			}
+			if msg, ok := d.Headers[headerRPCError].(string); ok {
+				return resp, &RemoteError{Message: msg}
+			}
+			return decode[Resp](d, ContentTypeJSON)
+		case <-ctx.Done():
+			return resp, ctx.Err()
+		}
+	}
+}
+
+// Serve answers requests published with Call. The reply is encoded with the
+// request's content type, and an error returned by handler is passed back to
+// the caller as a *RemoteError.
+func Serve[Req, Resp any](ctx context.Context, b Broker, pub Publisher, exchange, queueName, key string, simpleQueueType SimpleQueueType, handler func(context.Context, Req, Envelope) (Resp, error), opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, b, exchange, queueName, key, simpleQueueType, func(ctx context.Context, req Req, env Envelope) AckType {
+		if env.ReplyTo == "" {
+			logger().Warn("discarding request without reply-to", "queue", env.Queue, "message_id", env.MessageID)
+			return NackDiscard
+		}
+
+		resp, err := handler(ctx, req, env)
+		replyOpts := []PublishOption{WithCausation(env)}
+		if env.ContentType != "" {
+			replyOpts = append(replyOpts, WithContentType(env.ContentType))
+		}
+		if err != nil {
+			replyOpts = append(replyOpts, withHeader(headerRPCError, err.Error()))
+		}
+
+		err = Publish(ctx, pub, "", env.ReplyTo, resp, replyOpts...)
+		var returned *ReturnedError
+		if errors.As(err, &returned) {
+			logger().Info("caller stopped waiting for reply", "queue", env.Queue, "message_id", env.MessageID)
+			return Ack
+		}
+		if err != nil {
+			logger().Error("unable to publish reply", "queue", env.Queue, "message_id", env.MessageID, "err", err)
+			return NackRequeue
+		}
+		return Ack
+	}, opts)
+}
+
+func withReplyTo(queue string) PublishOption {
+	return func(o *publishOptions) {
+		o.replyTo = queue
+	}
+}
+
+func withExpiration(ms int64) PublishOption {
+	return func(o *publishOptions) {
+		o.expiration = strconv.FormatInt(ms, 10)
+	}
+}
+
+func withHeader(key string, val any) PublishOption {
+	return func(o *publishOptions) {
+		if o.headers == nil {
+			o.headers = amqp.Table{}
+		}
+		o.headers[key] = val
+	}
+}
@@ -0,0 +1,55 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestCallServe(t *testing.T) {
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeDirect, true, false, false, false, nil)
+
+	var returned *ReturnedError
+	if _, err := Call[string, int](context.Background(), conn, "x", "len", "hi"); !errors.As(err, &returned) {
+		t.Fatalf("Call with no server: err = %v, want a ReturnedError", err)
+	}
+
+	sub, err := Serve(context.Background(), conn, ch, "x", "len", "len", AutoDelete, func(_ context.Context, s string, _ Envelope) (int, error) {
+		if s == "" {
+			return 0, errors.New("empty string")
+		}
+		return len(s), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Call[string, int](context.Background(), conn, "x", "len", "hello")
+	if err != nil || n != 5 {
+		t.Fatalf("Call = %d, %v, want 5", n, err)
+	}
+	n, err = Call[string, int](context.Background(), conn, "x", "len", "gob", WithContentType(ContentTypeGob))
+	if err != nil || n != 3 {
+		t.Fatalf("gob Call = %d, %v, want 3", n, err)
+	}
+	var remote *RemoteError
+	if _, err := Call[string, int](context.Background(), conn, "x", "len", ""); !errors.As(err, &remote) || remote.Message != "empty string" {
+		t.Fatalf("failing Call: err = %v, want the handler's error", err)
+	}
+
+	// Once the last server has gone its queue is deleted, so callers fail
+	// fast rather than waiting out their timeout.
+	sub.Close()
+	sub.Wait()
+	start := time.Now()
+	if _, err := Call[string, int](context.Background(), conn, "x", "len", "hi"); !errors.As(err, &returned) {
+		t.Fatalf("Call after the server stopped: err = %v, want a ReturnedError", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Call after the server stopped took %s", d)
+	}
+}
@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PublishAt publishes val to exchange with key at the given time and
+// returns an ID for CancelScheduled, which is also the message ID.
+//
+// Each scheduled message waits in its own delay queue whose TTL
+// dead-letters it to exchange, so no broker plugin is needed and any
+// process can cancel it by deleting the queue.
+func PublishAt[T any](ctx context.Context, b Broker, pub Publisher, at time.Time, exchange, key string, val T, opts ...PublishOption) (string, error) {
+	delay := max(time.Until(at), 0)
+	id := NewMessageID()
+	queue := scheduledQueue(id)
+
+	ch, err := b.Channel()
+	if err != nil {
+		return "", err
+	}
+	defer ch.Close()
+	_, err = ch.QueueDeclare(queue, true, false, false, false, amqp.Table{
+		// Round up, so the message is never published early.
+		"x-message-ttl":             (delay + time.Millisecond - 1).Milliseconds(),
+		"x-dead-letter-exchange":    exchange,
+		"x-dead-letter-routing-key": key,
+		"x-expires":                 (delay + time.Minute).Milliseconds(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = Publish(ctx, pub, "", queue, val, append(slices.Clip(opts), WithMessageID(id))...)
+	if err != nil {
+		ch.QueueDelete(queue, false, false, false)
+		return "", err
+	}
+	return id, nil
+}
+
+// PublishAfter is PublishAt for a time delay from now.
+func PublishAfter[T any](ctx context.Context, b Broker, pub Publisher, delay time.Duration, exchange, key string, val T, opts ...PublishOption) (string, error) {
+	return PublishAt(ctx, b, pub, time.Now().Add(delay), exchange, key, val, opts...)
+}
+
+// CancelScheduled stops a message scheduled with PublishAt from being
+// published. It reports false if the message had already been published or
+// cancelled.
+func CancelScheduled(b Broker, id string) (bool, error) {
+	ch, err := b.Channel()
+	if err != nil {
+		return false, err
+	}
+	defer ch.Close()
+	n, err := ch.QueueDelete(scheduledQueue(id), false, false, false)
+	return n > 0, err
+}
+
+func scheduledQueue(id string) string {
+	return routing.ScheduledPrefix + "." + id
+}
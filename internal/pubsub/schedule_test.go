@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPublishAfter(t *testing.T) {
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeDirect, true, false, false, false, nil)
+	got := make(chan string, 4)
+	_, err := SubscribeToJSONWithContext(context.Background(), conn, "x", "q", "pause", Transient, func(s string) AckType {
+		got <- s
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := PublishAfter(context.Background(), conn, ch, 100*time.Millisecond, "x", "pause", "later"); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := PublishAfter(context.Background(), conn, ch, 150*time.Millisecond, "x", "pause", "cancelled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := CancelScheduled(conn, cancelled); !ok || err != nil {
+		t.Fatalf("CancelScheduled = %v, %v, want true", ok, err)
+	}
+
+	select {
+	case s := <-got:
+		if s != "later" {
+			t.Fatalf("got %q, want later", s)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("published after %v, want at least 100ms", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the scheduled message")
+	}
+	select {
+	case s := <-got:
+		t.Fatalf("cancelled message %q was published", s)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if ok, _ := CancelScheduled(conn, cancelled); ok {
+		t.Fatal("cancelled the same message twice")
+	}
+}
+
+func TestPublishAtPast(t *testing.T) {
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare("x", amqp.ExchangeDirect, true, false, false, false, nil)
+	ch.QueueDeclare("q", false, true, false, false, nil)
+	ch.QueueBind("q", "k", "x", false, nil)
+	deliveries, err := ch.Consume("q", "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := PublishAt(context.Background(), conn, ch, time.Now().Add(-time.Hour), "x", "k", "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := receive(t, deliveries); d.MessageId != id {
+		t.Fatalf("message ID %q, want the schedule ID %q", d.MessageId, id)
+	}
+}
@@ -0,0 +1,976 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// STOMP support targets RabbitMQ's STOMP plugin. Exchanges are addressed as
+// /exchange/<name>/<key> destinations, so a STOMP process can publish and
+// subscribe alongside AMQP ones, but it cannot declare exchanges, fetch
+// single messages, purge queues or schedule messages with PublishAt, and
+// unroutable messages are dropped rather than returned. Queues are only
+// created once something consumes from them, so the topology itself has to
+// be declared over AMQP.
+
+const (
+	stompHeartbeat      = 10 * time.Second
+	stompRequestTimeout = 10 * time.Second
+)
+
+// stompProperties are the STOMP headers RabbitMQ maps to and from AMQP
+// message properties.
+var stompProperties = map[string]bool{
+	"content-type":     true,
+	"content-encoding": true,
+	"persistent":       true,
+	"priority":         true,
+	"expiration":       true,
+	"reply-to":         true,
+	"correlation-id":   true,
+	"amqp-message-id":  true,
+	"timestamp":        true,
+	"type":             true,
+	"app-id":           true,
+	"user-id":          true,
+}
+
+func stompUnsupported(op string) error {
+	return fmt.Errorf("pubsub: STOMP does not support %s: %w", op, errors.ErrUnsupported)
+}
+
+type stompFrame struct {
+	command string
+	headers map[string]string
+	body    []byte
+}
+
+// DialSTOMP is like Dial for stomp:// and stomps:// URLs. The URL's user
+// info is used as login and passcode and its path as the virtual host.
+func DialSTOMP(url string, cfg *tls.Config, opts ...ConnectionOption) (*Connection, error) {
+	c := newConnection(opts)
+	if c.connectionName() != "" {
+		return nil, stompUnsupported("connection names")
+	}
+	if c.externalAuth() {
+		return nil, stompUnsupported("EXTERNAL authentication")
+	}
+	c.dial = func() (Broker, error) {
+		return dialSTOMP(url, cfg)
+	}
+	return c.start()
+}
+
+type stompBroker struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu        sync.Mutex
+	closing   bool
+	closed    bool
+	nextID    uint64
+	consumers map[string]*stompConsumer
+	receipts  map[string]func(error)
+	channels  map[*stompChannel]struct{}
+	notify    []chan *amqp.Error
+	done      chan struct{}
+}
+
+func dialSTOMP(rawURL string, cfg *tls.Config) (*stompBroker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "stomp":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "61613")
+		}
+		conn, err = net.DialTimeout("tcp", host, stompRequestTimeout)
+	case "stomps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "61614")
+		}
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: stompRequestTimeout}, "tcp", host, cfg)
+	default:
+		return nil, fmt.Errorf("pubsub: unsupported STOMP scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vhost := strings.TrimPrefix(u.Path, "/")
+	if vhost == "" {
+		vhost = "/"
+	}
+	connect := stompFrame{command: "CONNECT", headers: map[string]string{
+		"accept-version": "1.2",
+		"host":           vhost,
+		"heart-beat":     fmt.Sprintf("%d,%d", stompHeartbeat.Milliseconds(), stompHeartbeat.Milliseconds()),
+	}}
+	if u.User != nil {
+		connect.headers["login"] = u.User.Username()
+		connect.headers["passcode"], _ = u.User.Password()
+	}
+
+	dr := &deadlineReader{conn: conn}
+	b := &stompBroker{
+		conn:      conn,
+		r:         bufio.NewReader(dr),
+		w:         bufio.NewWriter(conn),
+		consumers: map[string]*stompConsumer{},
+		receipts:  map[string]func(error){},
+		channels:  map[*stompChannel]struct{}{},
+		done:      make(chan struct{}),
+	}
+	conn.SetDeadline(time.Now().Add(stompRequestTimeout))
+	err = b.write(connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	connected, err := readStompFrame(b.r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if connected.command != "CONNECTED" {
+		conn.Close()
+		return nil, stompError(connected)
+	}
+	conn.SetDeadline(time.Time{})
+
+	send, recv := negotiateHeartbeat(connected.headers["heart-beat"])
+	// Missing three server heart-beats in a row means the connection is gone.
+	dr.timeout = 3 * recv
+	if send > 0 {
+		go b.heartbeat(send)
+	}
+	go b.readLoop()
+	return b, nil
+}
+
+// negotiateHeartbeat returns how often this side has to send and how often
+// it can expect to hear from the server, given the server's heart-beat
+// header.
+func negotiateHeartbeat(header string) (send, recv time.Duration) {
+	sx, sy, _ := strings.Cut(header, ",")
+	serverSends, _ := strconv.Atoi(sx)
+	serverWants, _ := strconv.Atoi(sy)
+	if serverWants > 0 {
+		send = max(stompHeartbeat, time.Duration(serverWants)*time.Millisecond)
+	}
+	if serverSends > 0 {
+		recv = max(stompHeartbeat, time.Duration(serverSends)*time.Millisecond)
+	}
+	return send, recv
+}
+
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if r.timeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	return r.conn.Read(p)
+}
+
+func (b *stompBroker) heartbeat(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			b.writeMu.Lock()
+			b.w.WriteByte('\n')
+			err := b.w.Flush()
+			b.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *stompBroker) Channel() (Channel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &stompChannel{
+		b:         b,
+		queues:    map[string]*stompQueue{},
+		consumers: map[string][]string{},
+		acks:      map[uint64]string{},
+	}
+	b.channels[ch] = struct{}{}
+	return ch, nil
+}
+
+func (b *stompBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	b.closing = true
+	b.mu.Unlock()
+	// A receipted DISCONNECT makes sure everything sent so far was processed.
+	b.request(stompFrame{command: "DISCONNECT", headers: map[string]string{}})
+	b.shutdown(nil)
+	return nil
+}
+
+func (b *stompBroker) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(receiver)
+		return receiver
+	}
+	b.notify = append(b.notify, receiver)
+	return receiver
+}
+
+func (b *stompBroker) shutdown(err *amqp.Error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.done)
+	b.conn.Close()
+	for _, c := range b.consumers {
+		c.close()
+	}
+	b.consumers = map[string]*stompConsumer{}
+	receipts := b.receipts
+	b.receipts = map[string]func(error){}
+	channels := b.channels
+	b.channels = map[*stompChannel]struct{}{}
+	notify := b.notify
+	b.notify = nil
+	b.mu.Unlock()
+
+	for _, done := range receipts {
+		done(amqp.ErrClosed)
+	}
+	for ch := range channels {
+		ch.shutdown()
+	}
+	for _, receiver := range notify {
+		if err != nil {
+			select {
+			case receiver <- err:
+			default:
+			}
+		}
+		close(receiver)
+	}
+}
+
+func (b *stompBroker) genID(prefix string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	return fmt.Sprintf("%s-%d", prefix, b.nextID)
+}
+
+func (b *stompBroker) write(f stompFrame) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	err := writeStompFrame(b.w, f)
+	if err != nil {
+		return err
+	}
+	return b.w.Flush()
+}
+
+// send writes f with a receipt header and calls done once the server has
+// processed it, or with the error that stopped it from doing so.
+func (b *stompBroker) send(f stompFrame, done func(error)) error {
+	id := b.genID("receipt")
+	f.headers["receipt"] = id
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	b.receipts[id] = done
+	b.mu.Unlock()
+
+	err := b.write(f)
+	if err != nil {
+		b.mu.Lock()
+		delete(b.receipts, id)
+		b.mu.Unlock()
+	}
+	return err
+}
+
+// request is a synchronous send.
+func (b *stompBroker) request(f stompFrame) error {
+	result := make(chan error, 1)
+	err := b.send(f, func(err error) { result <- err })
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-result:
+		return err
+	case <-time.After(stompRequestTimeout):
+		return fmt.Errorf("pubsub: no STOMP receipt for %s", f.command)
+	}
+}
+
+func (b *stompBroker) readLoop() {
+	for {
+		f, err := readStompFrame(b.r)
+		if err != nil {
+			b.mu.Lock()
+			closing := b.closing
+			b.mu.Unlock()
+			if closing {
+				b.shutdown(nil)
+			} else {
+				b.shutdown(&amqp.Error{Code: amqp.FrameError, Reason: err.Error(), Recover: true})
+			}
+			return
+		}
+
+		switch f.command {
+		case "MESSAGE":
+			b.mu.Lock()
+			c := b.consumers[f.headers["subscription"]]
+			b.mu.Unlock()
+			if c != nil {
+				c.ch.deliver(c, f)
+			}
+		case "RECEIPT":
+			b.mu.Lock()
+			done := b.receipts[f.headers["receipt-id"]]
+			delete(b.receipts, f.headers["receipt-id"])
+			b.mu.Unlock()
+			if done != nil {
+				done(nil)
+			}
+		case "ERROR":
+			// The server closes the connection after an ERROR frame.
+			err := stompError(f)
+			b.mu.Lock()
+			done := b.receipts[f.headers["receipt-id"]]
+			delete(b.receipts, f.headers["receipt-id"])
+			b.mu.Unlock()
+			if done != nil {
+				done(err)
+			}
+			b.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: err.Error(), Server: true, Recover: true})
+			return
+		}
+	}
+}
+
+func stompError(f stompFrame) error {
+	msg := f.headers["message"]
+	if body := strings.TrimSpace(string(f.body)); body != "" {
+		msg += ": " + body
+	}
+	return fmt.Errorf("pubsub: STOMP %s: %s", strings.ToLower(f.command), msg)
+}
+
+type stompQueue struct {
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+	args       amqp.Table
+	bindings   [][2]string
+}
+
+// headers describes q in the form RabbitMQ accepts on SUBSCRIBE and SEND, so
+// the queue is declared the way QueueDeclare asked for.
+func (q *stompQueue) headers(name string) map[string]string {
+	h := map[string]string{
+		"x-queue-name": name,
+		"durable":      strconv.FormatBool(q.durable),
+		"auto-delete":  strconv.FormatBool(q.autoDelete),
+		"exclusive":    strconv.FormatBool(q.exclusive),
+	}
+	for k, v := range q.args {
+		h[k] = fmt.Sprint(v)
+	}
+	return h
+}
+
+type stompChannel struct {
+	b *stompBroker
+
+	mu        sync.Mutex
+	closed    bool
+	queues    map[string]*stompQueue
+	prefetch  int
+	consumers map[string][]string
+	nextTag   uint64
+	acks      map[uint64]string
+	confirm   bool
+	seq       uint64
+	confirms  []chan amqp.Confirmation
+	returns   []chan amqp.Return
+}
+
+// stompStreamOffset formats an x-stream-offset consumer argument the way
+// the STOMP plugin expects it.
+func stompStreamOffset(v any) string {
+	switch v := v.(type) {
+	case int64:
+		return fmt.Sprintf("offset=%d", v)
+	case time.Time:
+		return fmt.Sprintf("timestamp=%d", v.Unix())
+	}
+	return fmt.Sprint(v)
+}
+
+func stompDestination(exchange, key string) string {
+	escape := func(s string) string { return strings.ReplaceAll(s, "/", "%2F") }
+	if exchange == "" {
+		return "/amq/queue/" + escape(key)
+	}
+	return "/exchange/" + escape(exchange) + "/" + escape(key)
+}
+
+func (ch *stompChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f := stompFrame{command: "SEND", headers: map[string]string{}, body: msg.Body}
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	q := ch.queues[key]
+	if exchange == "" && q != nil {
+		// A /queue destination declares the queue if it is not there yet.
+		f.headers = q.headers(key)
+		f.headers["destination"] = "/queue/" + key
+	} else {
+		f.headers["destination"] = stompDestination(exchange, key)
+	}
+	confirm := ch.confirm
+	if confirm {
+		ch.seq++
+	}
+	seq := ch.seq
+	ch.mu.Unlock()
+
+	for k, v := range msg.Headers {
+		switch v.(type) {
+		case amqp.Table, []any, []byte:
+			continue
+		}
+		if k == "content-length" {
+			continue
+		}
+		f.headers[k] = fmt.Sprint(v)
+	}
+	setHeader := func(k, v string) {
+		if v != "" {
+			f.headers[k] = v
+		}
+	}
+	setHeader("content-type", msg.ContentType)
+	setHeader("content-encoding", msg.ContentEncoding)
+	setHeader("expiration", msg.Expiration)
+	setHeader("reply-to", msg.ReplyTo)
+	setHeader("correlation-id", msg.CorrelationId)
+	setHeader("amqp-message-id", msg.MessageId)
+	setHeader("type", msg.Type)
+	setHeader("app-id", msg.AppId)
+	setHeader("user-id", msg.UserId)
+	if msg.DeliveryMode == amqp.Persistent {
+		f.headers["persistent"] = "true"
+	}
+	if msg.Priority != 0 {
+		f.headers["priority"] = strconv.Itoa(int(msg.Priority))
+	}
+	if !msg.Timestamp.IsZero() {
+		f.headers["timestamp"] = strconv.FormatInt(msg.Timestamp.Unix(), 10)
+	}
+
+	if !confirm {
+		return ch.b.write(f)
+	}
+	return ch.b.send(f, func(err error) {
+		ch.confirmed(amqp.Confirmation{DeliveryTag: seq, Ack: err == nil})
+	})
+}
+
+func (ch *stompChannel) confirmed(c amqp.Confirmation) {
+	// Holding mu keeps shutdown from closing a listener mid-send.
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for _, l := range ch.confirms {
+		select {
+		case l <- c:
+		case <-ch.b.done:
+		}
+	}
+}
+
+func (ch *stompChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if name == "" {
+		name = ch.b.genID("stomp.gen")
+	}
+	_, hasTTL := args["x-message-ttl"]
+	_, hasKey := args["x-dead-letter-routing-key"]
+	if dlx, _ := args["x-dead-letter-exchange"].(string); hasTTL && hasKey && dlx != "" {
+		// PublishAt's delay queues only exist once a SEND on this channel
+		// creates them, and cancelling one means deleting it.
+		return amqp.Queue{}, stompUnsupported("delayed publishing to an exchange")
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	q := ch.queues[name]
+	if q == nil {
+		q = &stompQueue{}
+		ch.queues[name] = q
+	}
+	q.durable, q.autoDelete, q.exclusive, q.args = durable, autoDelete, exclusive, args
+	return amqp.Queue{Name: name}, nil
+}
+
+// QueueBind only records the binding. RabbitMQ creates it when the queue is
+// consumed from.
+func (ch *stompChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	q := ch.queues[name]
+	if q == nil {
+		q = &stompQueue{durable: true}
+		ch.queues[name] = q
+	}
+	if exchange != "" {
+		q.bindings = append(q.bindings, [2]string{exchange, key})
+	}
+	return nil
+}
+
+func (ch *stompChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.prefetch = prefetchCount
+	return nil
+}
+
+func (ch *stompChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if queue == directReplyTo {
+		return nil, stompUnsupported("direct reply-to")
+	}
+	if consumer == "" {
+		consumer = ch.b.genID("ctag")
+	}
+
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return nil, amqp.ErrClosed
+	}
+	var frames []map[string]string
+	if q := ch.queues[queue]; q == nil {
+		frames = append(frames, map[string]string{"destination": stompDestination("", queue)})
+	} else if len(q.bindings) == 0 {
+		h := q.headers(queue)
+		h["destination"] = "/queue/" + queue
+		frames = append(frames, h)
+	} else {
+		// Each binding is its own subscription, all on the same queue.
+		for _, binding := range q.bindings {
+			h := q.headers(queue)
+			h["destination"] = stompDestination(binding[0], binding[1])
+			frames = append(frames, h)
+		}
+	}
+	prefetch := ch.prefetch
+	ch.mu.Unlock()
+
+	c := newStompConsumer(ch, consumer)
+	var ids []string
+	for _, h := range frames {
+		id := ch.b.genID("sub")
+		h["id"] = id
+		h["ack"] = "client-individual"
+		if autoAck {
+			h["ack"] = "auto"
+		}
+		if prefetch > 0 {
+			h["prefetch-count"] = strconv.Itoa(prefetch)
+		}
+		if offset, ok := args[headerStreamOffset]; ok {
+			h[headerStreamOffset] = stompStreamOffset(offset)
+		}
+		ch.b.mu.Lock()
+		ch.b.consumers[id] = c
+		ch.b.mu.Unlock()
+		ids = append(ids, id)
+
+		err := ch.b.request(stompFrame{command: "SUBSCRIBE", headers: h})
+		if err != nil {
+			ch.unsubscribe(ids)
+			c.close()
+			return nil, err
+		}
+	}
+
+	ch.mu.Lock()
+	ch.consumers[consumer] = ids
+	ch.mu.Unlock()
+	return c.out, nil
+}
+
+func (ch *stompChannel) unsubscribe(ids []string) {
+	for _, id := range ids {
+		ch.b.request(stompFrame{command: "UNSUBSCRIBE", headers: map[string]string{"id": id}})
+		ch.b.mu.Lock()
+		c := ch.b.consumers[id]
+		delete(ch.b.consumers, id)
+		ch.b.mu.Unlock()
+		if c != nil {
+			c.close()
+		}
+	}
+}
+
+func (ch *stompChannel) Cancel(consumer string, noWait bool) error {
+	ch.mu.Lock()
+	ids := ch.consumers[consumer]
+	delete(ch.consumers, consumer)
+	ch.mu.Unlock()
+	ch.unsubscribe(ids)
+	return nil
+}
+
+func (ch *stompChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	return amqp.Delivery{}, false, stompUnsupported("basic.get")
+}
+
+func (ch *stompChannel) QueuePurge(name string, noWait bool) (int, error) {
+	return 0, stompUnsupported("queue.purge")
+}
+
+func (ch *stompChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	return 0, stompUnsupported("queue.delete")
+}
+
+// ExchangeDeclare is a no-op: STOMP cannot declare exchanges, so they must
+// already exist.
+func (ch *stompChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+// Confirm makes every publish carry a receipt header. The RECEIPT frame
+// stands in for the publisher confirm.
+func (ch *stompChannel) Confirm(noWait bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirm = true
+	return nil
+}
+
+func (ch *stompChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(confirm)
+		return confirm
+	}
+	ch.confirms = append(ch.confirms, confirm)
+	return confirm
+}
+
+// NotifyReturn never delivers anything, since STOMP has no mandatory flag.
+func (ch *stompChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(c)
+		return c
+	}
+	ch.returns = append(ch.returns, c)
+	return c
+}
+
+func (ch *stompChannel) Close() error {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	var ids []string
+	for _, consumer := range ch.consumers {
+		ids = append(ids, consumer...)
+	}
+	ch.consumers = map[string][]string{}
+	ch.mu.Unlock()
+
+	ch.unsubscribe(ids)
+	ch.b.mu.Lock()
+	delete(ch.b.channels, ch)
+	ch.b.mu.Unlock()
+	ch.shutdown()
+	return nil
+}
+
+func (ch *stompChannel) shutdown() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	for _, c := range ch.confirms {
+		close(c)
+	}
+	for _, c := range ch.returns {
+		close(c)
+	}
+	ch.confirms, ch.returns = nil, nil
+}
+
+func (ch *stompChannel) deliver(c *stompConsumer, f stompFrame) {
+	ch.mu.Lock()
+	ch.nextTag++
+	tag := ch.nextTag
+	if ack := f.headers["ack"]; ack != "" {
+		ch.acks[tag] = ack
+	}
+	ch.mu.Unlock()
+
+	d := amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         amqp.Table{},
+		ContentType:     f.headers["content-type"],
+		ContentEncoding: f.headers["content-encoding"],
+		CorrelationId:   f.headers["correlation-id"],
+		ReplyTo:         f.headers["reply-to"],
+		Expiration:      f.headers["expiration"],
+		MessageId:       f.headers["amqp-message-id"],
+		Type:            f.headers["type"],
+		UserId:          f.headers["user-id"],
+		AppId:           f.headers["app-id"],
+		ConsumerTag:     c.tag,
+		DeliveryTag:     tag,
+		Redelivered:     f.headers["redelivered"] == "true",
+		Body:            f.body,
+	}
+	if f.headers["persistent"] == "true" {
+		d.DeliveryMode = amqp.Persistent
+	}
+	if p, err := strconv.Atoi(f.headers["priority"]); err == nil {
+		d.Priority = uint8(p)
+	}
+	if ts, err := strconv.ParseInt(f.headers["timestamp"], 10, 64); err == nil {
+		d.Timestamp = time.Unix(ts, 0).UTC()
+	}
+	d.Exchange, d.RoutingKey = parseStompDestination(f.headers["destination"])
+	for k, v := range f.headers {
+		switch k {
+		case "destination", "message-id", "subscription", "ack", "redelivered", "content-length":
+			continue
+		}
+		if !stompProperties[k] {
+			d.Headers[k] = v
+		}
+	}
+	c.push(d)
+}
+
+func parseStompDestination(dest string) (exchange, key string) {
+	unescape := func(s string) string { return strings.ReplaceAll(s, "%2F", "/") }
+	switch {
+	case strings.HasPrefix(dest, "/exchange/"):
+		exchange, key, _ = strings.Cut(strings.TrimPrefix(dest, "/exchange/"), "/")
+		return unescape(exchange), unescape(key)
+	case strings.HasPrefix(dest, "/topic/"):
+		return "amq.topic", unescape(strings.TrimPrefix(dest, "/topic/"))
+	case strings.HasPrefix(dest, "/amq/queue/"):
+		return "", unescape(strings.TrimPrefix(dest, "/amq/queue/"))
+	case strings.HasPrefix(dest, "/queue/"):
+		return "", unescape(strings.TrimPrefix(dest, "/queue/"))
+	}
+	return "", dest
+}
+
+func (ch *stompChannel) settle(tag uint64, multiple bool, command string, headers map[string]string) error {
+	ch.mu.Lock()
+	var ids []string
+	for t, id := range ch.acks {
+		if t == tag || multiple && t < tag {
+			ids = append(ids, id)
+			delete(ch.acks, t)
+		}
+	}
+	closed := ch.closed
+	ch.mu.Unlock()
+	if closed {
+		return amqp.ErrClosed
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("pubsub: unknown delivery tag %d", tag)
+	}
+
+	for _, id := range ids {
+		f := stompFrame{command: command, headers: map[string]string{"id": id}}
+		for k, v := range headers {
+			f.headers[k] = v
+		}
+		err := ch.b.write(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ch *stompChannel) Ack(tag uint64, multiple bool) error {
+	return ch.settle(tag, multiple, "ACK", nil)
+}
+
+// Nack relies on RabbitMQ's requeue header; plain STOMP would always
+// discard.
+func (ch *stompChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	return ch.settle(tag, multiple, "NACK", map[string]string{"requeue": strconv.FormatBool(requeue)})
+}
+
+func (ch *stompChannel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+type stompConsumer struct {
+	*deliveryBuffer
+	ch  *stompChannel
+	tag string
+}
+
+func newStompConsumer(ch *stompChannel, tag string) *stompConsumer {
+	return &stompConsumer{deliveryBuffer: newDeliveryBuffer(), ch: ch, tag: tag}
+}
+
+var stompEscaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+var stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+
+func writeStompFrame(w *bufio.Writer, f stompFrame) error {
+	escape := f.command != "CONNECT" && f.command != "CONNECTED"
+	w.WriteString(f.command)
+	w.WriteByte('\n')
+	for k, v := range f.headers {
+		if escape {
+			k, v = stompEscaper.Replace(k), stompEscaper.Replace(v)
+		}
+		w.WriteString(k)
+		w.WriteByte(':')
+		w.WriteString(v)
+		w.WriteByte('\n')
+	}
+	if f.body != nil {
+		fmt.Fprintf(w, "content-length:%d\n", len(f.body))
+	}
+	w.WriteByte('\n')
+	w.Write(f.body)
+	return w.WriteByte(0)
+}
+
+func readStompFrame(r *bufio.Reader) (stompFrame, error) {
+	var f stompFrame
+	// Blank lines between frames are heart-beats.
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return f, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line != "" {
+			f.command = line
+			break
+		}
+	}
+
+	escaped := f.command != "CONNECTED"
+	f.headers = map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return f, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return f, fmt.Errorf("pubsub: malformed STOMP header %q", line)
+		}
+		if escaped {
+			k, v = stompUnescaper.Replace(k), stompUnescaper.Replace(v)
+		}
+		// Repeated headers keep their first value.
+		if _, ok := f.headers[k]; !ok {
+			f.headers[k] = v
+		}
+	}
+
+	if n, err := strconv.Atoi(f.headers["content-length"]); err == nil {
+		f.body = make([]byte, n+1)
+		_, err = io.ReadFull(r, f.body)
+		if err != nil {
+			return f, err
+		}
+		if f.body[n] != 0 {
+			return f, errors.New("pubsub: STOMP frame body is not NUL-terminated")
+		}
+		f.body = f.body[:n]
+		return f, nil
+	}
+	body, err := r.ReadBytes(0)
+	if err != nil {
+		return f, err
+	}
+	f.body = bytes.TrimSuffix(body, []byte{0})
+	return f, nil
+}
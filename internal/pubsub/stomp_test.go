@@ -0,0 +1,214 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSTOMP is just enough of RabbitMQ's STOMP plugin to route SEND frames
+// on /exchange destinations to matching subscriptions and record how each
+// message was settled.
+type fakeSTOMP struct {
+	mu         sync.Mutex
+	subscribes []map[string]string
+	subs       map[string]fakeSTOMPSub
+	keys       map[string]string // ack id -> routing key
+	settled    chan string
+	nextAck    int
+}
+
+type fakeSTOMPSub struct {
+	id    string
+	write func(stompFrame)
+}
+
+func newFakeSTOMP(t *testing.T) (*fakeSTOMP, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSTOMP{
+		subs:    map[string]fakeSTOMPSub{},
+		keys:    map[string]string{},
+		settled: make(chan string, 16),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, "stomp://guest:guest@" + ln.Addr().String() + "/"
+}
+
+func (s *fakeSTOMP) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	var writeMu sync.Mutex
+	write := func(f stompFrame) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		writeStompFrame(w, f)
+		w.Flush()
+	}
+	for {
+		f, err := readStompFrame(r)
+		if err != nil {
+			return
+		}
+		switch f.command {
+		case "CONNECT":
+			write(stompFrame{command: "CONNECTED", headers: map[string]string{"version": "1.2", "heart-beat": "0,0"}})
+		case "SUBSCRIBE":
+			s.mu.Lock()
+			s.subscribes = append(s.subscribes, f.headers)
+			s.subs[f.headers["destination"]] = fakeSTOMPSub{f.headers["id"], write}
+			s.mu.Unlock()
+		case "SEND":
+			s.route(f)
+		case "ACK", "NACK":
+			s.mu.Lock()
+			key := s.keys[f.headers["id"]]
+			s.mu.Unlock()
+			settle := f.command + " " + key
+			if requeue := f.headers["requeue"]; requeue != "" {
+				settle += " requeue=" + requeue
+			}
+			s.settled <- settle
+		}
+		if id := f.headers["receipt"]; id != "" {
+			write(stompFrame{command: "RECEIPT", headers: map[string]string{"receipt-id": id}})
+		}
+	}
+}
+
+func (s *fakeSTOMP) route(f stompFrame) {
+	exchange, key := parseStompDestination(f.headers["destination"])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dest, sub := range s.subs {
+		subExchange, pattern := parseStompDestination(dest)
+		if subExchange != exchange || !topicMatch(pattern, key) {
+			continue
+		}
+		s.nextAck++
+		ack := "ack-" + strconv.Itoa(s.nextAck)
+		s.keys[ack] = key
+		h := map[string]string{"subscription": sub.id, "ack": ack, "message-id": ack}
+		for k, v := range f.headers {
+			if k != "receipt" {
+				h[k] = v
+			}
+		}
+		sub.write(stompFrame{command: "MESSAGE", headers: h, body: f.body})
+	}
+}
+
+func TestSTOMPPublishSubscribe(t *testing.T) {
+	s, url := newFakeSTOMP(t)
+	conn, err := DialSTOMP(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	acks := map[string]AckType{
+		"army_moves.ack":     Ack,
+		"army_moves.discard": NackDiscard,
+		"army_moves.requeue": NackRequeue,
+	}
+	_, err = SubscribeHandler(context.Background(), conn, "peril_topic", "army_moves.bob", "army_moves.*", Transient,
+		func(_ context.Context, move string, env Envelope) AckType {
+			if move != env.RoutingKey {
+				t.Errorf("got %q on %s", move, env.RoutingKey)
+			}
+			return acks[env.RoutingKey]
+		}, WithPrefetch(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	if len(s.subscribes) != 1 {
+		t.Fatalf("%d SUBSCRIBE frames, want 1", len(s.subscribes))
+	}
+	sub := s.subscribes[0]
+	s.mu.Unlock()
+	want := map[string]string{
+		"destination":    "/exchange/peril_topic/army_moves.*",
+		"x-queue-name":   "army_moves.bob",
+		"ack":            "client-individual",
+		"prefetch-count": "5",
+	}
+	for k, v := range want {
+		if sub[k] != v {
+			t.Errorf("SUBSCRIBE %s = %q, want %q", k, sub[k], v)
+		}
+	}
+
+	// The confirming publisher waits for each SEND's RECEIPT.
+	pub := conn.ConfirmingPublisher()
+	for _, key := range []string{"army_moves.ack", "army_moves.discard", "army_moves.requeue"} {
+		if err := PublishJSON(pub, "peril_topic", key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[string]bool{}
+	for range acks {
+		select {
+		case settle := <-s.settled:
+			got[settle] = true
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for settlements, got %v", got)
+		}
+	}
+	for _, settle := range []string{"ACK army_moves.ack", "NACK army_moves.discard requeue=false", "NACK army_moves.requeue requeue=true"} {
+		if !got[settle] {
+			t.Errorf("missing %q in %v", settle, got)
+		}
+	}
+}
+
+func TestSTOMPUnsupported(t *testing.T) {
+	_, url := newFakeSTOMP(t)
+	conn, err := DialSTOMP(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ch.Get("army_moves.bob", false); err == nil {
+		t.Error("Get succeeded over STOMP")
+	}
+	if _, err := ch.QueuePurge("army_moves.bob", false); err == nil {
+		t.Error("QueuePurge succeeded over STOMP")
+	}
+}
+
+func TestSTOMPPublishAtUnsupported(t *testing.T) {
+	_, url := newFakeSTOMP(t)
+	conn, err := DialSTOMP(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = PublishAfter(context.Background(), conn, conn.ConfirmingPublisher(), time.Second, "peril_direct", "pause", "resume")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("err = %v, want errors.ErrUnsupported", err)
+	}
+}
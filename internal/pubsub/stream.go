@@ -0,0 +1,172 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	headerStreamOffset = "x-stream-offset"
+	queueTypeStream    = "stream"
+)
+
+// StreamOffset is where a stream consumer starts reading.
+type StreamOffset struct {
+	value any
+}
+
+var (
+	// OffsetFirst starts at the oldest message still in the stream.
+	OffsetFirst = StreamOffset{"first"}
+	// OffsetLast starts at the last chunk written to the stream, so the
+	// most recent messages are replayed.
+	OffsetLast = StreamOffset{"last"}
+	// OffsetNext only delivers messages published after the consumer
+	// starts. It is the default.
+	OffsetNext = StreamOffset{"next"}
+)
+
+// OffsetAt starts at the message with the given offset.
+func OffsetAt(offset int64) StreamOffset {
+	return StreamOffset{offset}
+}
+
+// OffsetTimestamp starts at the first chunk written at or after t.
+func OffsetTimestamp(t time.Time) StreamOffset {
+	return StreamOffset{t.Truncate(time.Second)}
+}
+
+func (o StreamOffset) String() string {
+	if o.value == nil {
+		return "next"
+	}
+	return fmt.Sprint(o.value)
+}
+
+func (o StreamOffset) arg() any {
+	if o.value == nil {
+		return "next"
+	}
+	return o.value
+}
+
+// StreamDeclaration is a stream queue bound to an exchange. Streams keep
+// messages after they are consumed, until MaxAge or MaxLengthBytes is
+// exceeded, so they can be read again from any offset.
+type StreamDeclaration struct {
+	Name           string
+	Exchange       string
+	Key            string
+	MaxAge         time.Duration
+	MaxLengthBytes int64
+}
+
+func (s StreamDeclaration) args() amqp.Table {
+	args := amqp.Table{"x-queue-type": queueTypeStream}
+	if s.MaxAge > 0 {
+		args["x-max-age"] = fmt.Sprintf("%ds", int64(s.MaxAge.Seconds()))
+	}
+	if s.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = s.MaxLengthBytes
+	}
+	return args
+}
+
+// DeclareStream declares s on ch and binds it to its exchange.
+func DeclareStream(ch Channel, s StreamDeclaration) error {
+	_, err := ch.QueueDeclare(s.Name, true, false, false, false, s.args())
+	if err != nil {
+		return err
+	}
+	return ch.QueueBind(s.Name, s.Key, s.Exchange, false, nil)
+}
+
+// StreamOffsetOf reports the offset of a delivery read from a stream.
+func StreamOffsetOf(env Envelope) (int64, bool) {
+	return tableInt(env.Headers, headerStreamOffset)
+}
+
+// SubscribeStream reads the stream queue named stream, which must already be
+// declared, starting at offset. Reading a stream never removes messages from
+// it, so every delivery is acked whatever the handler returns. After a
+// reconnect the subscription resumes after the last handled offset; with
+// WithConcurrency above one that may skip messages that were still in
+// flight.
+func SubscribeStream[T any](ctx context.Context, b Broker, stream string, offset StreamOffset, handler Handler[T], opts ...SubscribeOption) (*Subscription, error) {
+	var (
+		mu      sync.Mutex
+		last    int64
+		handled bool
+	)
+	h := func(ctx context.Context, msg T, env Envelope) AckType {
+		ack := handler(ctx, msg, env)
+		if ack != Ack {
+			logger().Warn("stream deliveries are always acked", "stream", stream, "ack", ack)
+		}
+		if n, ok := StreamOffsetOf(env); ok {
+			mu.Lock()
+			if !handled || n > last {
+				last, handled = n, true
+			}
+			mu.Unlock()
+		}
+		return Ack
+	}
+	consumeArgs := func() amqp.Table {
+		mu.Lock()
+		defer mu.Unlock()
+		if handled {
+			return amqp.Table{headerStreamOffset: last + 1}
+		}
+		return amqp.Table{headerStreamOffset: offset.arg()}
+	}
+	declare := func(b Broker) (Channel, amqp.Queue, error) {
+		ch, err := b.Channel()
+		if err != nil {
+			return nil, amqp.Queue{}, err
+		}
+		return ch, amqp.Queue{Name: stream}, nil
+	}
+	return consumeQueue(ctx, b, declare, consumeArgs, h, newSubscribeOptions(opts))
+}
+
+// ReplayStream reads stream from offset until no message has arrived for
+// idle, which on a quiet stream means it has caught up, and returns how many
+// messages were read.
+func ReplayStream[T any](ctx context.Context, b Broker, stream string, offset StreamOffset, idle time.Duration, handler func(T, Envelope), opts ...SubscribeOption) (int, error) {
+	var n atomic.Int64
+	activity := make(chan struct{}, 1)
+	sub, err := SubscribeStream(ctx, b, stream, offset, func(_ context.Context, msg T, env Envelope) AckType {
+		handler(msg, env)
+		n.Add(1)
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
+		return Ack
+	}, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		sub.Close()
+		sub.Wait()
+	}()
+
+	for {
+		select {
+		case <-activity:
+		case <-time.After(idle):
+			sub.Close()
+			sub.Wait()
+			return int(n.Load()), nil
+		case <-ctx.Done():
+			return int(n.Load()), ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package pubsub
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func declareHistory(t *testing.T, b Broker) {
+	t.Helper()
+	if err := DeclareTopology(b, PerilTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeclareTopology(b, HistoryTopology(routing.DefaultExchanges())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamOffsets(t *testing.T) {
+	b := NewMemoryBroker()
+	conn := newTestConnection(t, b)
+	declareHistory(t, conn)
+	pub := conn.Publisher()
+	for i := 0; i < 5; i++ {
+		PublishGob(pub, routing.ExchangePerilTopic, routing.GameLogSlug+".bob", routing.GameLog{Username: "bob"})
+	}
+
+	tests := []struct {
+		offset StreamOffset
+		want   []int64
+	}{
+		{OffsetFirst, []int64{0, 1, 2, 3, 4}},
+		{OffsetAt(3), []int64{3, 4}},
+		{OffsetLast, []int64{4}},
+		{OffsetTimestamp(time.Now().Add(-time.Minute)), []int64{0, 1, 2, 3, 4}},
+		{OffsetNext, nil},
+	}
+	for _, tt := range tests {
+		var got []int64
+		n, err := ReplayStream(context.Background(), conn, routing.GameLogStream, tt.offset, 50*time.Millisecond, func(_ routing.GameLog, env Envelope) {
+			offset, _ := StreamOffsetOf(env)
+			got = append(got, offset)
+		}, WithDefaultContentType(ContentTypeGob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(got) || !slices.Equal(got, tt.want) {
+			t.Errorf("replay from %v read %d: %v, want %v", tt.offset, n, got, tt.want)
+		}
+	}
+}
+
+func TestStreamResumesAfterReconnect(t *testing.T) {
+	b := NewMemoryBroker()
+	reconnected := make(chan struct{}, 1)
+	conn := newTestConnection(t, b, OnReconnected(func() { reconnected <- struct{}{} }))
+	declareHistory(t, conn)
+	pub := conn.ConfirmingPublisher()
+	for i := 0; i < 3; i++ {
+		PublishGob(pub, routing.ExchangePerilTopic, routing.GameLogSlug+".bob", routing.GameLog{Username: "bob"})
+	}
+
+	got := make(chan int64, 10)
+	sub, err := SubscribeStream(context.Background(), conn, routing.GameLogStream, OffsetFirst, func(_ context.Context, _ routing.GameLog, env Envelope) AckType {
+		offset, _ := StreamOffsetOf(env)
+		got <- offset
+		return Ack
+	}, WithDefaultContentType(ContentTypeGob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	for want := int64(0); want < 3; want++ {
+		select {
+		case n := <-got:
+			if n != want {
+				t.Fatalf("read offset %d, want %d", n, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out reading the stream")
+		}
+	}
+
+	b.Restart()
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("did not reconnect")
+	}
+	if err := PublishGob(pub, routing.ExchangePerilTopic, routing.GameLogSlug+".bob", routing.GameLog{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-got:
+		if n != 3 {
+			t.Fatalf("resumed at offset %d, want 3", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the subscription did not resume")
+	}
+	select {
+	case n := <-got:
+		t.Fatalf("offset %d read twice", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHistoryTopology(t *testing.T) {
+	if s := PerilTopology(routing.DefaultExchanges()).Streams; len(s) != 0 {
+		t.Fatalf("PerilTopology declares streams %v; only the server should", s)
+	}
+	for _, s := range HistoryTopology(routing.DefaultExchanges()).Streams {
+		args := s.args()
+		if args["x-max-age"] != "604800s" || args["x-max-length-bytes"] != int64(1<<30) {
+			t.Errorf("%s retention: %v, want a week and 1GiB", s.Name, args)
+		}
+	}
+
+	// The streams must not be redeclared with other retention settings.
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	declareHistory(t, conn)
+	s := HistoryTopology(routing.DefaultExchanges()).Streams[0]
+	s.MaxAge = time.Hour
+	if err := DeclareStream(ch, s); err == nil {
+		t.Fatal("redeclared a stream with a different x-max-age")
+	}
+}
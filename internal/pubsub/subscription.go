@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var consumerSeq atomic.Uint64
+
+// Subscription is a handle on a running consumer. Close cancels the consumer
+// so no new deliveries arrive, and Wait blocks until every delivery already
+// handed to the consumer has been handled and settled.
+type Subscription struct {
+	mu     sync.Mutex
+	ch     Channel
+	tag    string
+	closed bool
+	active int
+	done   chan struct{}
+	// unregister stops the subscription being restarted after a reconnect.
+	unregister func()
+
+	decodeCounters decodeCounters
+}
+
+func newSubscription() *Subscription {
+	return &Subscription{done: make(chan struct{})}
+}
+
+func newConsumerTag(queue string) string {
+	return fmt.Sprintf("peril-%s-%d", queue, consumerSeq.Add(1))
+}
+
+func (s *Subscription) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	ch, tag, unregister := s.ch, s.tag, s.unregister
+	if s.active == 0 {
+		close(s.done)
+	}
+	s.mu.Unlock()
+
+	if unregister != nil {
+		unregister()
+	}
+
+	if ch == nil {
+		return nil
+	}
+	err := ch.Cancel(tag, false)
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Subscription) Wait() {
+	<-s.done
+}
+
+// Done is closed once the subscription is closed and fully drained.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// DecodeStats reports how many deliveries failed to decode and what was
+// done with them.
+func (s *Subscription) DecodeStats() DecodeStats {
+	return s.decodeCounters.stats()
+}
+
+func (s *Subscription) onClose(unregister func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unregister = unregister
+}
+
+func (s *Subscription) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Subscription) attach(ch Channel, tag string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.ch, s.tag = ch, tag
+	s.active++
+	return true
+}
+
+func (s *Subscription) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if s.closed && s.active == 0 {
+		close(s.done)
+	}
+}
+
+func (s *Subscription) closeOnDone(ctx context.Context) {
+	if ctx.Done() == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+}
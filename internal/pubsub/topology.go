@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type ExchangeDeclaration struct {
+	Name string
+	Kind string
+}
+
+type QueueDeclaration struct {
+	Name     string
+	Exchange string
+	Key      string
+}
+
+// Topology is the set of durable exchanges and queues a process owns and
+// declares at startup, before any subscriber binds to them.
+type Topology struct {
+	Exchanges []ExchangeDeclaration
+	Queues    []QueueDeclaration
+	Streams   []StreamDeclaration
+}
+
+// PerilTopology declares the game's exchanges under the given names and the
+// dead-letter queue.
+func PerilTopology(ex routing.Exchanges) Topology {
+	return Topology{
+		Exchanges: []ExchangeDeclaration{
+			{Name: ex.Direct, Kind: amqp.ExchangeDirect},
+			{Name: ex.Topic, Kind: amqp.ExchangeTopic},
+			{Name: ex.DLX, Kind: amqp.ExchangeFanout},
+		},
+		Queues: []QueueDeclaration{
+			{Name: routing.DeadLetterQueue, Exchange: ex.DLX, Key: ""},
+		},
+	}
+}
+
+// Game history is kept for a week, and each stream is capped at 1GiB so a
+// busy game cannot fill the broker's disk within that week.
+const (
+	historyMaxAge         = 7 * 24 * time.Hour
+	historyMaxLengthBytes = 1 << 30
+)
+
+// HistoryTopology is the game log and army move streams that game history
+// is replayed from. Only the server declares them, after PerilTopology;
+// clients just read them.
+func HistoryTopology(ex routing.Exchanges) Topology {
+	return Topology{
+		Streams: []StreamDeclaration{
+			{Name: routing.GameLogStream, Exchange: ex.Topic, Key: routing.GameLogSlug + ".*", MaxAge: historyMaxAge, MaxLengthBytes: historyMaxLengthBytes},
+			{Name: routing.ArmyMovesStream, Exchange: ex.Topic, Key: routing.ArmyMovesPrefix + ".*", MaxAge: historyMaxAge, MaxLengthBytes: historyMaxLengthBytes},
+		},
+	}
+}
+
+// DeclareTopology declares every exchange, queue and stream in t. On a managed
+// Connection the topology is declared again after each reconnect, ahead of
+// the subscriptions that depend on it.
+func DeclareTopology(b Broker, t Topology) error {
+	declare := func(b Broker) error {
+		ch, err := b.Channel()
+		if err != nil {
+			return err
+		}
+		defer ch.Close()
+
+		for _, ex := range t.Exchanges {
+			err = ch.ExchangeDeclare(ex.Name, ex.Kind, true, false, false, false, nil)
+			if err != nil {
+				return err
+			}
+		}
+		for _, q := range t.Queues {
+			_, err = ch.QueueDeclare(q.Name, true, false, false, false, nil)
+			if err != nil {
+				return err
+			}
+			err = ch.QueueBind(q.Name, q.Key, q.Exchange, false, nil)
+			if err != nil {
+				return err
+			}
+		}
+		for _, s := range t.Streams {
+			err = DeclareStream(ch, s)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := declare(b)
+	if err != nil {
+		return err
+	}
+	if r, ok := b.(recoverer); ok {
+		r.onRecover(declare)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package pubsub
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub")
+
+// headerCarrier lets the global propagator read and write trace context in
+// AMQP headers.
+type headerCarrier amqp.Table
+
+var _ propagation.TextMapCarrier = headerCarrier(nil)
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func startPublishSpan(ctx context.Context, exchange, key string, msg *amqp.Publishing) (context.Context, trace.Span) {
+	destination := exchange
+	if destination == "" {
+		destination = "(default)"
+	}
+	ctx, span := tracer.Start(ctx, "publish "+destination,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(destination),
+			semconv.MessagingRabbitmqDestinationRoutingKey(key),
+			semconv.MessagingMessageID(msg.MessageId),
+			semconv.MessagingMessageConversationID(msg.CorrelationId),
+		),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Headers))
+	return ctx, span
+}
+
+func startConsumeSpan(ctx context.Context, queue string, d amqp.Delivery) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(d.Headers))
+	return tracer.Start(ctx, "process "+queue,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(queue),
+			semconv.MessagingRabbitmqDestinationRoutingKey(d.RoutingKey),
+			semconv.MessagingMessageID(d.MessageId),
+			semconv.MessagingMessageConversationID(d.CorrelationId),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func recordAck(span trace.Span, ack AckType) {
+	span.SetAttributes(attribute.String("peril.ack", ack.String()))
+	if ack == NackDiscard {
+		span.SetStatus(codes.Error, "message discarded")
+	}
+}
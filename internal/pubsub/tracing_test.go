@@ -0,0 +1,84 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spanRecorderOnce sync.Once
+	spanRecorder     *tracetest.SpanRecorder
+)
+
+// recordSpans installs a global tracer provider that records every span. The
+// package tracer delegates to the first global provider that is set, so it
+// is installed once and tests tell their spans apart by name.
+func recordSpans() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return spanRecorder
+}
+
+func TestTracePropagation(t *testing.T) {
+	rec := recordSpans()
+	exchange, queue := "traced-"+NewMessageID(), "traced-"+NewMessageID()
+
+	conn, ch := newTestChannel(t, NewMemoryBroker())
+	ch.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	done := make(chan struct{})
+	sub, err := SubscribeHandler(context.Background(), conn, exchange, queue, "#", Durable, func(ctx context.Context, s string, env Envelope) AckType {
+		if s == "first" {
+			PublishJSONWithContext(ctx, ch, exchange, "second", "second")
+		} else {
+			close(done)
+		}
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	PublishJSON(ch, exchange, "first", "first")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the second message")
+	}
+	sub.Close()
+	sub.Wait()
+
+	var publishes, processes []sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		switch s.Name() {
+		case "publish " + exchange:
+			publishes = append(publishes, s)
+		case "process " + queue:
+			processes = append(processes, s)
+		}
+	}
+	if len(publishes) != 2 || len(processes) != 2 {
+		t.Fatalf("recorded %d publish and %d process spans, want 2 of each", len(publishes), len(processes))
+	}
+
+	// publish first -> process first -> publish second -> process second
+	chain := []sdktrace.ReadOnlySpan{publishes[0], processes[0], publishes[1], processes[1]}
+	if publishes[0].Parent().IsValid() {
+		chain = []sdktrace.ReadOnlySpan{publishes[1], processes[0], publishes[0], processes[1]}
+	}
+	for i := 1; i < len(chain); i++ {
+		parent, child := chain[i-1], chain[i]
+		if child.Parent().SpanID() != parent.SpanContext().SpanID() || child.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("%s is not a child of %s", child.Name(), parent.Name())
+		}
+	}
+}
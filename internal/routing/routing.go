@@ -7,10 +7,51 @@ const (
 
 	PauseKey = "pause"
 
+	// PlayerPrefix names each player's queue, which gets both pause
+	// messages and army moves so pauses can overtake queued moves.
+	PlayerPrefix = "player"
+
+	GameStateKey = "game_state"
+
 	GameLogSlug = "game_logs"
+
+	DeadLetterQueue = "peril_dlq"
+
+	// ScheduledPrefix names the delay queues that hold scheduled messages.
+	ScheduledPrefix = "peril_scheduled"
+
+	// Streams keep a replayable copy of every game log and army move.
+	GameLogStream   = "game_logs.stream"
+	ArmyMovesStream = "army_moves.stream"
+)
+
+// Control messages such as pause and resume outrank gameplay messages on
+// queues declared with MaxPriority, as long as they share the queue.
+const (
+	MaxPriority      uint8 = 10
+	PriorityGameplay uint8 = 0
+	PriorityControl  uint8 = 10
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDLX    = "peril_dlx"
 )
+
+// Exchanges names the exchanges a game runs on. Configuration can rename
+// them, so the binaries take the names from here rather than the constants.
+type Exchanges struct {
+	Direct string
+	Topic  string
+	DLX    string
+}
+
+// DefaultExchanges returns the exchanges named by the constants.
+func DefaultExchanges() Exchanges {
+	return Exchanges{
+		Direct: ExchangePerilDirect,
+		Topic:  ExchangePerilTopic,
+		DLX:    ExchangePerilDLX,
+	}
+}
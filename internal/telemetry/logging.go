@@ -0,0 +1,45 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// SetupLogging builds the diagnostics logger and makes it the slog default.
+// It writes to path, or to stderr if path is empty, so diagnostics can be
+// kept out of the game's stdout. level is one of debug, info, warn or error
+// and format is text or json. The returned func closes the log file.
+func SetupLogging(path, level, format string) (*slog.Logger, func() error, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, nil, fmt.Errorf("telemetry: invalid log level %q", level)
+	}
+
+	var w io.Writer = os.Stderr
+	closeLog := func() error { return nil }
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closeLog = f, f.Close
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		closeLog()
+		return nil, nil, fmt.Errorf("telemetry: unknown log format %q", format)
+	}
+	logger := slog.New(h)
+	slog.SetDefault(logger)
+	return logger, closeLog, nil
+}
@@ -0,0 +1,31 @@
+// Package telemetry wires up the observability endpoints shared by the
+// Peril binaries.
+package telemetry
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ServeMetrics exposes the default Prometheus registry at /metrics on addr
+// in the background. Stop it with Shutdown on the returned server.
+func ServeMetrics(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", "addr", addr, "err", err)
+		}
+	}()
+	return srv, nil
+}
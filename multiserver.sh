@@ -17,15 +17,22 @@ cleanup() {
   for pid in "${pids[@]}"; do
     kill -SIGTERM "$pid"
   done
+  # Give each server the chance to finish writing in-flight game logs
+  wait
   exit
 }
 
 # Setup trap for SIGINT
 trap 'cleanup' SIGINT
 
+# Build once so signals reach the servers directly instead of `go run`
+bin=$(mktemp -d)/peril-server
+go build -o "$bin" ./cmd/server || exit 1
+
 # Start the specified number of instances of the program in the background
 for (( i=0; i<num_instances; i++ )); do
-  go run ./cmd/server &
+  # MQTT uses the connection name as the client ID, which must be unique.
+  "$bin" -broker-connection-name "peril-server-$(hostname)-$i" &
   pids+=($!)
 done
 
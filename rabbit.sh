@@ -3,17 +3,47 @@
 case "$1" in
     start)
         echo "Starting RabbitMQ container..."
-        docker run -d --rm --name rabbitmq -p 5672:5672 -p 15672:15672 rabbitmq:3.13-management
+        docker build -q -t peril-rabbitmq . >/dev/null
+        docker run -d --rm --name rabbitmq -p 5672:5672 -p 61613:61613 -p 1883:1883 -p 15672:15672 peril-rabbitmq
+        ;;
+    start-tls)
+        if [ ! -f certs/ca.pem ]; then
+            ./certs.sh
+        fi
+        echo "Starting RabbitMQ container with amqps on 5671 and mqtts on 8883..."
+        docker build -q -t peril-rabbitmq . >/dev/null
+        # The server key is only readable by its owner, so the broker gets a
+        # copy owned by the rabbitmq user inside the container.
+        docker run -d --rm --name rabbitmq -p 5672:5672 -p 5671:5671 -p 61613:61613 -p 1883:1883 -p 8883:8883 -p 15672:15672 \
+            -v "$PWD/certs:/certs-host:ro" \
+            -v "$PWD/rabbitmq-tls.conf:/etc/rabbitmq/conf.d/20-tls.conf:ro" \
+            peril-rabbitmq \
+            sh -c "mkdir -p /certs && cp /certs-host/ca.pem /certs-host/server.pem /certs && \
+                install -o rabbitmq -g rabbitmq -m 600 /certs-host/server.key /certs/server.key && \
+                rabbitmq-plugins enable --offline rabbitmq_auth_mechanism_ssl && \
+                exec docker-entrypoint.sh rabbitmq-server"
+        docker exec rabbitmq sh -c 'until rabbitmqctl await_startup >/dev/null 2>&1; do sleep 1; done'
+        # The EXTERNAL user must exist, but never logs in with a password.
+        docker exec rabbitmq rabbitmqctl add_user peril "$(head -c 24 /dev/urandom | base64)"
+        docker exec rabbitmq rabbitmqctl set_permissions peril ".*" ".*" ".*"
+        ;;
+    start-mosquitto)
+        # RabbitMQ refuses MQTT shared subscriptions, which the war and
+        # game_logs queues need, so MQTT games run against Mosquitto.
+        echo "Starting Mosquitto container with mqtt on 1884..."
+        docker run -d --rm --name mosquitto -p 1884:1883 eclipse-mosquitto:2 \
+            mosquitto -c /mosquitto-no-auth.conf
         ;;
     stop)
         echo "Stopping RabbitMQ container..."
         docker stop rabbitmq
+        docker stop mosquitto 2>/dev/null
         ;;
     logs)
         echo "Fetching logs for RabbitMQ container..."
         docker logs -f rabbitmq
         ;;
     *)
-        echo "Usage: $0 {start|stop|logs}"
+        echo "Usage: $0 {start|start-tls|start-mosquitto|stop|logs}"
         exit 1
 esac